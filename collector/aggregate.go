@@ -0,0 +1,118 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidateAggregatedMetrics checks that every metric can be aggregated,
+// only gauges are aggregated and container state is not aggregated,
+// because it is added to stats after aggregation
+func ValidateAggregatedMetrics(names []string) error {
+	gauges := map[string]struct{}{}
+
+	for _, m := range (Stats{}).Metrics() {
+		if m.Kind == Gauge && !strings.HasPrefix(m.Name, "container.") {
+			gauges[m.Name] = struct{}{}
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := gauges[name]; !ok {
+			return fmt.Errorf("metric %q is not a gauge that can be aggregated", name)
+		}
+	}
+
+	return nil
+}
+
+// aggregator keeps min, max and avg of gauge metrics
+// between reports, so short spikes are not lost
+type aggregator struct {
+	names  map[string]struct{}
+	values map[string]*aggregate
+}
+
+type aggregate struct {
+	min   MetricValue
+	max   MetricValue
+	sum   float64
+	count int
+}
+
+func newAggregator(names []string) *aggregator {
+	a := &aggregator{
+		names:  map[string]struct{}{},
+		values: map[string]*aggregate{},
+	}
+
+	for _, name := range names {
+		a.names[name] = struct{}{}
+	}
+
+	return a
+}
+
+// add accounts gauge metrics of stats that should be aggregated
+func (a *aggregator) add(s Stats) {
+	if len(a.names) == 0 {
+		return
+	}
+
+	for _, m := range s.Metrics() {
+		if m.Kind != Gauge {
+			continue
+		}
+
+		if _, ok := a.names[m.Name]; !ok {
+			continue
+		}
+
+		v, ok := a.values[m.Name]
+		if !ok {
+			a.values[m.Name] = &aggregate{min: m.Value, max: m.Value, sum: m.Value.Float(), count: 1}
+			continue
+		}
+
+		if m.Value.Float() < v.min.Float() {
+			v.min = m.Value
+		}
+
+		if m.Value.Float() > v.max.Float() {
+			v.max = m.Value
+		}
+
+		v.sum += m.Value.Float()
+		v.count++
+	}
+}
+
+// flush returns aggregated metrics and starts over
+func (a *aggregator) flush() []Metric {
+	if len(a.values) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(a.values))
+	for name := range a.values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	metrics := make([]Metric, 0, len(names)*3)
+	for _, name := range names {
+		v := a.values[name]
+
+		metrics = append(metrics,
+			Metric{name + ".min", Gauge, v.min},
+			Metric{name + ".max", Gauge, v.max},
+			Metric{name + ".avg", Gauge, floatValue(v.sum / float64(v.count))},
+		)
+	}
+
+	a.values = map[string]*aggregate{}
+
+	return metrics
+}
@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestAggregator(t *testing.T) {
+	a := newAggregator([]string{"memory.usage", "cpu.total"})
+
+	for _, usage := range []uint64{300, 100, 500} {
+		s := docker.Stats{}
+		s.MemoryStats.Usage = usage
+		s.CPUStats.CPUUsage.TotalUsage = usage
+
+		a.add(Stats{Stats: s})
+	}
+
+	values := map[string]float64{}
+	for _, m := range a.flush() {
+		values[m.Name] = m.Value.Float()
+	}
+
+	expected := map[string]float64{
+		"memory.usage.min": 100,
+		"memory.usage.max": 500,
+		"memory.usage.avg": 300,
+	}
+
+	if len(values) != len(expected) {
+		t.Errorf("expected only gauges to be aggregated, got %v", values)
+	}
+
+	for name, e := range expected {
+		if values[name] != e {
+			t.Errorf("expected %s to be %v, got %v", name, e, values[name])
+		}
+	}
+
+	if metrics := a.flush(); len(metrics) != 0 {
+		t.Errorf("expected nothing after flush, got %v", metrics)
+	}
+}
+
+func TestValidateAggregatedMetrics(t *testing.T) {
+	err := ValidateAggregatedMetrics([]string{"memory.usage", "cpu.percent", "blkio.queued"})
+	if err != nil {
+		t.Errorf("expected gauges to be valid, got %s", err)
+	}
+
+	for _, name := range []string{"memory.usgae", "cpu.total", "container.health", "task.restarts"} {
+		if ValidateAggregatedMetrics([]string{name}) == nil {
+			t.Errorf("expected %s to be rejected", name)
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"path"
 
@@ -16,6 +22,21 @@ func main() {
 	c := flag.String("cert", "", "cert path for tls")
 	h := flag.String("host", "", "host to report")
 	i := flag.Int("interval", 1, "interval to report")
+	r := flag.Int("reconcile", 5, "interval to check that every running container is monitored, 0 to disable")
+	d := flag.Bool("derive", false, "write cumulative metrics as collectd derive instead of gauge")
+	pc := flag.Bool("percpu", false, "report cpu usage for every core")
+	p := flag.String("procfs", "", "host procfs mount to read network stats of host networked containers")
+	a := flag.String("aggregate", "", "comma separated gauges to report min, max and avg for, like memory.usage,memory.rss")
+	w := flag.String("writer", "collectd", "stats writer: collectd, prometheus, graphite, influxdb or statsd")
+	l := flag.String("listen", ":9417", "address to serve prometheus metrics on")
+	g := flag.String("graphite", "127.0.0.1:2003", "carbon address for graphite writer")
+	gp := flag.String("graphite-prefix", "collectd.", "metric prefix for graphite writer")
+	gb := flag.Int("graphite-buffer", 100000, "lines to buffer while carbon is unavailable")
+	f := flag.String("influxdb", "http://127.0.0.1:8086/write?db=docker", "influxdb http write endpoint or udp://host:port")
+	fb := flag.Int("influxdb-buffer", 10000, "stats to buffer while influxdb is slow or unavailable")
+	s := flag.String("statsd", "127.0.0.1:8125", "statsd address for statsd writer")
+	sp := flag.String("statsd-prefix", "", "metric prefix for statsd writer")
+	st := flag.Bool("dogstatsd", false, "send app, task, host and container as dogstatsd tags")
 	flag.Parse()
 
 	if *h == "" {
@@ -23,6 +44,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	options := collector.Options{
+		PerCPUMetrics: *pc,
+		ProcfsRoot:    *p,
+	}
+
+	if *a != "" {
+		options.AggregatedMetrics = strings.Split(*a, ",")
+
+		err := collector.ValidateAggregatedMetrics(options.AggregatedMetrics)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	var client *docker.Client
 	var err error
 
@@ -36,11 +71,64 @@ func main() {
 		log.Fatal(err)
 	}
 
-	writer := collector.NewCollectdWriter(*h, os.Stdout)
+	var writer collector.StatsWriter
+
+	switch *w {
+	case "collectd":
+		writer = collector.NewCollectdWriter(*h, os.Stdout, *i, *d)
+	case "prometheus":
+		pw := collector.NewPrometheusWriter(*h, time.Duration(*i)*3*time.Second)
+
+		http.Handle("/metrics", pw)
+		go func() {
+			log.Fatal(http.ListenAndServe(*l, nil))
+		}()
+
+		writer = pw
+	case "graphite":
+		writer, err = collector.NewGraphiteWriter(*h, *gp, *g, *gb)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "influxdb":
+		writer, err = collector.NewInfluxDBWriter(*h, *f, *fb)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "statsd":
+		writer, err = collector.NewStatsdWriter(*h, *sp, *s, *st)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown writer %q", *w)
+	}
+
+	// health check state and memory reservation
+	// are only decoded by wrapped client
+	dc, err := collector.NewDockerClient(client, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	collector := collector.NewCollector(dc, writer, *i, options)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		s := <-signals
+		log.Printf("received %s, shutting down\n", s)
+
+		// the next signal kills collector if shutdown hangs
+		signal.Stop(signals)
 
-	collector := collector.NewCollector(client, writer, *i)
+		cancel()
+	}()
 
-	err = collector.Run(5)
+	err = collector.Run(ctx, *r)
 	if err != nil {
 		log.Fatal(err)
 	}
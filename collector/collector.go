@@ -1,77 +1,333 @@
 package collector
 
 import (
+	"context"
+	"io"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 )
 
+// minReconnectDelay and maxReconnectDelay are bounds of the delay
+// before reconnecting to docker event stream
+var (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// taskStatsTTL is how long to report crash counters
+// of a task that does not have known containers
+const taskStatsTTL = 10 * time.Minute
+
+// CollectorDockerClient represents restricted interface for docker client
+// that is used in collector, docker.Client is a subset of this interface
+type CollectorDockerClient interface {
+	MonitorDockerClient
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	RemoveEventListener(listener chan *docker.APIEvents) error
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+}
+
 // Collector is responsible for discovering containers
 // for monitoring and writing stats
 type Collector struct {
-	client     *docker.Client
+	client     CollectorDockerClient
 	ch         chan Stats
+	events     chan Event
+	notify     bool
 	mutex      sync.Mutex
-	registered map[string]struct{}
+	registered map[string]*Monitor
+	monitors   sync.WaitGroup
+	written    chan struct{}
+	ignored    map[string]struct{}
+	known      map[string]knownContainer
+	crashes    map[string]*taskCrashes
 	interval   int
+	options    Options
+}
+
+// knownContainer is what collector remembers about a monitored container
+type knownContainer struct {
+	app      string
+	task     string
+	restarts int
+	state    ContainerState
+}
+
+// taskCrashes are crash counters of a task and the last time
+// when the task had a known container
+type taskCrashes struct {
+	app     string
+	task    string
+	crashes CrashStats
+	seen    time.Time
 }
 
 // NewCollector creates new Collector with specified docker client,
-// collectd stats writer and stat updating interval
-func NewCollector(client *docker.Client, w CollectdWriter, interval int) *Collector {
-	ch := make(chan Stats)
+// stats writer, stat updating interval and monitor options, health
+// check state and memory reservation are reported if docker client
+// is DockerClient, container events are written as well if writer
+// implements EventWriter, task crash counters are written every interval
+// if writer implements TaskStatsWriter, writer is closed on shutdown
+// if it implements io.Closer
+func NewCollector(client CollectorDockerClient, w StatsWriter, interval int, options Options) *Collector {
+	ew, notify := w.(EventWriter)
+	tw, tasks := w.(TaskStatsWriter)
+
+	c := &Collector{
+		client:     client,
+		ch:         make(chan Stats),
+		events:     make(chan Event),
+		notify:     notify,
+		mutex:      sync.Mutex{},
+		registered: map[string]*Monitor{},
+		written:    make(chan struct{}),
+		ignored:    map[string]struct{}{},
+		known:      map[string]knownContainer{},
+		crashes:    map[string]*taskCrashes{},
+		interval:   interval,
+		options:    options,
+	}
 
 	// TODO: this can be better, need to figure out how
 	go func() {
-		for s := range ch {
-			w.Write(s)
+		defer close(c.written)
+
+		var tick <-chan time.Time
+		if tasks {
+			ticker := time.NewTicker(time.Duration(newSampler(interval).interval) * time.Second)
+			defer ticker.Stop()
+
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case s, ok := <-c.ch:
+				if !ok {
+					if tasks {
+						c.writeTaskStats(tw, time.Now())
+					}
+
+					c.closeWriter(w)
+					return
+				}
+
+				c.annotate(&s)
+
+				err := w.Write(s)
+				if err != nil {
+					log.Printf("error writing stats for app %s: %s\n", s.App, err)
+				}
+			case e := <-c.events:
+				err := ew.WriteEvent(e)
+				if err != nil {
+					log.Printf("error writing event for app %s: %s\n", e.App, err)
+				}
+			case now := <-tick:
+				c.writeTaskStats(tw, now)
+			}
 		}
 	}()
 
-	return &Collector{
-		client:     client,
-		ch:         ch,
-		mutex:      sync.Mutex{},
-		registered: map[string]struct{}{},
-		interval:   interval,
-	}
+	return c
 }
 
 // Run stats loop that discovers containers and runs
-// monitoring tasks for them
-func (c *Collector) Run(interval int) error {
+// monitoring tasks for them, reconnecting to docker
+// with backoff if event stream is interrupted, when
+// context is done every monitor is stopped and Run
+// returns after collected stats are written
+func (c *Collector) Run(ctx context.Context, interval int) error {
+	go c.reconcile(ctx, interval)
+
+	delay := minReconnectDelay
+
+	for {
+		synced, err := c.watch(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err != nil {
+			log.Printf("error watching docker events: %s\n", err)
+		} else {
+			log.Printf("docker event stream is closed\n")
+		}
+
+		if synced {
+			delay = minReconnectDelay
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+
+	c.shutdown()
+
+	return nil
+}
+
+// shutdown waits for stopped monitors and writes stats
+// they collected, context must be done before the call
+func (c *Collector) shutdown() {
+	// monitors cannot be registered after context is done,
+	// so the lock makes sure every registered one is counted
+	c.mutex.Lock()
+	c.mutex.Unlock()
+
+	c.monitors.Wait()
+
+	close(c.ch)
+	<-c.written
+}
+
+// closeWriter closes writer to deliver buffered stats
+func (c *Collector) closeWriter(w StatsWriter) {
+	closer, ok := w.(io.Closer)
+	if !ok {
+		return
+	}
+
+	err := closer.Close()
+	if err != nil {
+		log.Printf("error closing writer: %s\n", err)
+	}
+}
+
+// watch subscribes to docker events, starts monitoring of running
+// containers that are not monitored yet and handles events until
+// event stream is closed or context is done, synced is true
+// if containers were listed
+func (c *Collector) watch(ctx context.Context) (synced bool, err error) {
 	ch := make(chan *docker.APIEvents)
-	err := c.client.AddEventListener(ch)
+	err = c.client.AddEventListener(ch)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	defer c.client.RemoveEventListener(ch)
+	defer c.removeListener(ch)
 
 	containers, err := c.client.ListContainers(docker.ListContainersOptions{})
 	if err != nil {
-		return err
+		return false, err
 	}
 
+	// containers that are already monitored are skipped on registration
 	for _, container := range containers {
-		go c.handle(container.ID)
+		go c.handle(ctx, container.ID, false)
 	}
 
-	for e := range ch {
+	for {
+		var e *docker.APIEvents
+		var ok bool
+
+		select {
+		case e, ok = <-ch:
+			if !ok {
+				return true, nil
+			}
+		case <-ctx.Done():
+			return true, nil
+		}
+
+		if e.Type != "container" {
+			continue
+		}
+
+		// events of the previous run may be delivered after start
+		// of the restarted container, they must not stop its monitor
+		previous := c.isPreviousRun(e)
+
+		// paused containers are still monitored to report their state
 		switch e.Status {
-		case "start", "restart":
-			go c.handle(e.ID)
+		case "start":
+			go c.handle(ctx, e.ID, true)
+		case "restart":
+			go c.handle(ctx, e.ID, false)
+		case "oom":
+			c.oomKilled(e)
+		case "die":
+			c.died(e)
+			if !previous {
+				c.stop(e.ID)
+			}
+		case "stop":
+			if !previous {
+				c.stop(e.ID)
+			}
+		case "destroy":
+			c.stop(e.ID)
+		}
+
+		if !previous {
+			c.updateState(e.ID, e.Status)
+		}
+
+		if c.notify && isNotifiedEvent(e.Status) {
+			c.event(e)
+		}
+
+		if e.Status == "destroy" {
+			c.forget(e.ID)
 		}
 	}
+}
 
-	return nil
+// removeListener removes event listener, vendored client holds a lock
+// while it delivers an event and removal waits for the same lock,
+// so the listener is drained until it is removed
+func (c *Collector) removeListener(ch chan *docker.APIEvents) {
+	removed := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case _, ok := <-ch:
+				if !ok {
+					return
+				}
+			case <-removed:
+				return
+			}
+		}
+	}()
+
+	err := c.client.RemoveEventListener(ch)
+	if err != nil {
+		log.Printf("error removing docker event listener: %s\n", err)
+	}
+
+	close(removed)
 }
 
-func (c *Collector) handle(id string) {
-	m, err := NewMonitor(c.client, id, c.interval)
+// handle starts monitoring of the container, monitor that is
+// already running for the container is replaced if replace is set,
+// monitor is stopped when context is done
+func (c *Collector) handle(ctx context.Context, id string, replace bool) {
+	if c.isIgnored(id) {
+		return
+	}
+
+	m, err := NewMonitor(c.client, id, c.interval, c.options)
 	if err != nil {
 		if err == ErrNoNeedToMonitor {
+			c.ignore(id)
 			return
 		}
 
@@ -80,34 +336,431 @@ func (c *Collector) handle(id string) {
 		return
 	}
 
+	c.remember(m)
+
 	go func() {
-		if !c.register(id) {
+		if !c.register(ctx, m, replace) {
 			return
 		}
 
-		err := m.handle(c.ch)
+		defer c.monitors.Done()
+
+		err := m.run(ctx, c.ch)
 		if err != nil {
 			log.Printf("error handling container for app %s: %s\n", m.app, err)
 		}
 
-		c.unregister(id)
+		c.unregister(m)
 	}()
 }
 
-func (c *Collector) register(id string) bool {
+// reconcile periodically starts monitors for running containers
+// that are not monitored, in case start event was missed, and stops
+// monitors of containers that are not running anymore, reconciliation
+// is disabled if interval is not positive
+func (c *Collector) reconcile(ctx context.Context, interval int) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		c.reconcileOnce(ctx)
+	}
+}
+
+func (c *Collector) reconcileOnce(ctx context.Context) {
+	// monitors registered after listing may be missing from the list
+	registered := c.registeredIDs()
+
+	containers, err := c.client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		log.Printf("error listing containers: %s\n", err)
+		return
+	}
+
+	running := map[string]struct{}{}
+	for _, container := range containers {
+		running[container.ID] = struct{}{}
+
+		if !c.isRegistered(container.ID) {
+			go c.handle(ctx, container.ID, false)
+		}
+	}
+
+	for _, id := range registered {
+		if _, ok := running[id]; !ok {
+			log.Printf("stopping monitor of container %s that is not running\n", id)
+			c.stop(id)
+		}
+	}
+
+	c.forgetIgnored(running)
+}
+
+// event writes docker event of monitored container,
+// events of containers that are not monitored are skipped
+func (c *Collector) event(e *docker.APIEvents) {
+	// destroyed container cannot be inspected anymore
+	known, ok := c.container(e.ID, e.Status != "destroy")
+	if !ok {
+		return
+	}
+
+	event := Event{
+		ID:     e.ID,
+		App:    known.app,
+		Task:   known.task,
+		Status: e.Status,
+		Time:   eventTime(e),
+	}
+
+	if e.Status == "die" {
+		code, ok := eventExitCode(e)
+		if !ok {
+			container, err := c.client.InspectContainer(e.ID)
+			if err == nil {
+				code = container.State.ExitCode
+			}
+		}
+
+		event.ExitCode = code
+	}
+
+	c.events <- event
+}
+
+// died counts non-zero exits of monitored container, exit code is taken
+// from the event, because container with restart policy may be already
+// started again with exit code reset by the time it is inspected
+func (c *Collector) died(e *docker.APIEvents) {
+	known, ok := c.container(e.ID, true)
+	if !ok {
+		return
+	}
+
+	code, ok := eventExitCode(e)
+	if !ok {
+		// docker before 1.10 does not report exit code in events
+		container, err := c.client.InspectContainer(e.ID)
+		if err != nil {
+			log.Printf("error inspecting dead container for app %s: %s\n", known.app, err)
+			return
+		}
+
+		code = container.State.ExitCode
+	}
+
+	if code == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	c.crashStatsFor(known.app, known.task).Failures++
+	c.mutex.Unlock()
+}
+
+// oomKilled counts oom kills of monitored container
+func (c *Collector) oomKilled(e *docker.APIEvents) {
+	known, ok := c.container(e.ID, true)
+	if !ok {
+		return
+	}
+
+	c.mutex.Lock()
+	c.crashStatsFor(known.app, known.task).OOMKills++
+	c.mutex.Unlock()
+}
+
+// container returns what is known about the container, inspecting
+// the container if it was not seen yet and inspect is set, containers
+// that should not be monitored are remembered and not inspected again
+func (c *Collector) container(id string, inspect bool) (knownContainer, bool) {
+	c.mutex.Lock()
+	known, ok := c.known[id]
+	c.mutex.Unlock()
+
+	if ok || !inspect || c.isIgnored(id) {
+		return known, ok
+	}
+
+	m, err := NewMonitor(c.client, id, c.interval, c.options)
+	if err != nil {
+		if err == ErrNoNeedToMonitor {
+			c.ignore(id)
+		} else {
+			log.Printf("error handling event for %s: %s\n", id, err)
+		}
+
+		return knownContainer{}, false
+	}
+
+	return c.remember(m), true
+}
+
+// remember saves app and task of the container and counts restarts
+// that happened since the container was seen the last time
+func (c *Collector) remember(m *Monitor) knownContainer {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if _, ok := c.registered[id]; ok {
+	known := knownContainer{
+		app:      m.app,
+		task:     m.task,
+		restarts: m.restarts,
+		state:    m.state,
+	}
+
+	if prev, ok := c.known[m.id]; ok {
+		if m.restarts > prev.restarts {
+			c.crashStatsFor(m.app, m.task).Restarts += uint64(m.restarts - prev.restarts)
+		}
+
+		// health from events is kept if inspection did not report it
+		if known.state.Health == "" {
+			known.state.Health = prev.state.Health
+			known.state.FailingStreak = prev.state.FailingStreak
+		}
+	}
+
+	c.known[m.id] = known
+
+	return known
+}
+
+func (c *Collector) forget(id string) {
+	c.mutex.Lock()
+	delete(c.known, id)
+	delete(c.ignored, id)
+	c.mutex.Unlock()
+}
+
+// ignore remembers container that should not be monitored,
+// labels and environment cannot change, so it is never monitored
+func (c *Collector) ignore(id string) {
+	c.mutex.Lock()
+	c.ignored[id] = struct{}{}
+	c.mutex.Unlock()
+}
+
+// forgetIgnored forgets ignored containers that are not running,
+// so ignored containers that are removed do not pile up
+func (c *Collector) forgetIgnored(running map[string]struct{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for id := range c.ignored {
+		if _, ok := running[id]; !ok {
+			delete(c.ignored, id)
+		}
+	}
+}
+
+func (c *Collector) isIgnored(id string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, ok := c.ignored[id]
+	return ok
+}
+
+// isPreviousRun returns true if the event happened before
+// the known container was started
+func (c *Collector) isPreviousRun(e *docker.APIEvents) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	known, ok := c.known[e.ID]
+	if !ok || known.state.StartedAt.IsZero() {
 		return false
 	}
 
-	c.registered[id] = struct{}{}
+	return eventBefore(e, known.state.StartedAt)
+}
+
+// updateState tracks pause and health status of known container
+func (c *Collector) updateState(id, status string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	known, ok := c.known[id]
+	if !ok {
+		return
+	}
+
+	switch {
+	case status == "pause":
+		known.state.Paused = true
+	case status == "unpause":
+		known.state.Paused = false
+	case status == "die":
+		known.state.Running = false
+		known.state.Paused = false
+	case strings.HasPrefix(status, "health_status: "):
+		known.state.Health = strings.TrimPrefix(status, "health_status: ")
+		if known.state.Health == "healthy" {
+			known.state.FailingStreak = 0
+		}
+	default:
+		return
+	}
+
+	c.known[id] = known
+}
+
+// annotate adds container state to stats, health check state
+// refreshed by monitor is remembered
+func (c *Collector) annotate(s *Stats) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	known, ok := c.known[s.ID]
+	if !ok {
+		return
+	}
+
+	if s.State.Health != "" {
+		known.state.Health = s.State.Health
+		known.state.FailingStreak = s.State.FailingStreak
+		c.known[s.ID] = known
+	}
+
+	s.State = known.state
+}
+
+// crashStatsFor returns crash counters of the task,
+// mutex must be held by the caller
+func (c *Collector) crashStatsFor(app, task string) *CrashStats {
+	return &c.taskCrashesFor(app, task).crashes
+}
+
+// taskCrashesFor returns crash counters of the task with the time
+// when it was seen, mutex must be held by the caller
+func (c *Collector) taskCrashesFor(app, task string) *taskCrashes {
+	key := app + "." + task
+
+	t, ok := c.crashes[key]
+	if !ok {
+		t = &taskCrashes{app: app, task: task, seen: time.Now()}
+		c.crashes[key] = t
+	}
+
+	return t
+}
+
+// writeTaskStats writes crash counters of every task
+func (c *Collector) writeTaskStats(w TaskStatsWriter, now time.Time) {
+	for _, t := range c.taskStats(now) {
+		err := w.WriteTaskStats(t)
+		if err != nil {
+			log.Printf("error writing task stats for app %s: %s\n", t.App, err)
+		}
+	}
+}
+
+// taskStats returns crash counters of tasks that have known containers
+// or had them recently, counters of other tasks are forgotten
+func (c *Collector) taskStats(now time.Time) []TaskStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, known := range c.known {
+		c.taskCrashesFor(known.app, known.task).seen = now
+	}
+
+	keys := make([]string, 0, len(c.crashes))
+	for key, t := range c.crashes {
+		if now.Sub(t.seen) > taskStatsTTL {
+			delete(c.crashes, key)
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	stats := make([]TaskStats, 0, len(keys))
+	for _, key := range keys {
+		t := c.crashes[key]
+		stats = append(stats, TaskStats{App: t.app, Task: t.task, Time: now, Crashes: t.crashes})
+	}
+
+	return stats
+}
+
+// register tracks running monitor of the container, it returns false
+// if the container is already monitored and replace is not set
+// or if context is done and the collector is shutting down
+func (c *Collector) register(ctx context.Context, m *Monitor, replace bool) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if prev, ok := c.registered[m.id]; ok {
+		if !replace {
+			return false
+		}
+
+		prev.stop()
+	}
+
+	c.registered[m.id] = m
+	c.monitors.Add(1)
 	return true
 }
 
-func (c *Collector) unregister(id string) {
+// unregister removes finished monitor, newer monitor
+// of the same container is kept
+func (c *Collector) unregister(m *Monitor) {
 	c.mutex.Lock()
-	delete(c.registered, id)
+	if c.registered[m.id] == m {
+		delete(c.registered, m.id)
+	}
 	c.mutex.Unlock()
 }
+
+// stop stops monitoring of the container if it is monitored
+func (c *Collector) stop(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	m, ok := c.registered[id]
+	if !ok {
+		return
+	}
+
+	m.stop()
+	delete(c.registered, id)
+}
+
+func (c *Collector) isRegistered(id string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, ok := c.registered[id]
+	return ok
+}
+
+func (c *Collector) registeredIDs() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ids := make([]string, 0, len(c.registered))
+	for id := range c.registered {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
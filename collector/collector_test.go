@@ -0,0 +1,642 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+type fakeCollectorDockerClient struct {
+	mutex      sync.Mutex
+	events     sync.RWMutex
+	containers map[string]*docker.Container
+	health     map[string]*containerHealth
+	inspects   map[string]int
+	listeners  []chan<- *docker.APIEvents
+	listens    int
+	failures   int
+	inflight   int
+}
+
+func newFakeCollectorDockerClient() *fakeCollectorDockerClient {
+	return &fakeCollectorDockerClient{
+		containers: map[string]*docker.Container{},
+		health:     map[string]*containerHealth{},
+		inspects:   map[string]int{},
+	}
+}
+
+// add registers running container, app label is not set if app is empty
+func (f *fakeCollectorDockerClient) add(id, app string) {
+	labels := map[string]string{}
+	if app != "" {
+		labels[appLabel] = app
+	}
+
+	f.mutex.Lock()
+	f.containers[id] = &docker.Container{
+		ID:     id,
+		Config: &docker.Config{Labels: labels},
+		State:  docker.State{Running: true},
+	}
+	f.mutex.Unlock()
+}
+
+func (f *fakeCollectorDockerClient) inspected(id string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.inspects[id]
+}
+
+func (f *fakeCollectorDockerClient) InspectContainer(id string) (*docker.Container, error) {
+	container, _, err := f.inspectWithDetails(id)
+	return container, err
+}
+
+func (f *fakeCollectorDockerClient) inspectWithDetails(id string) (*docker.Container, *containerDetails, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.inspects[id]++
+
+	container, ok := f.containers[id]
+	if !ok {
+		return nil, nil, &docker.NoSuchContainer{ID: id}
+	}
+
+	details := &containerDetails{}
+	if health, ok := f.health[id]; ok {
+		copied := *health
+		details.State.Health = &copied
+	}
+
+	copied := *container
+	return &copied, details, nil
+}
+
+func (f *fakeCollectorDockerClient) Stats(opts docker.StatsOptions) error {
+	defer close(opts.Stats)
+	<-opts.Done
+	return nil
+}
+
+func (f *fakeCollectorDockerClient) AddEventListener(listener chan<- *docker.APIEvents) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.listens++
+
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("docker is unavailable")
+	}
+
+	f.listeners = append(f.listeners, listener)
+	return nil
+}
+
+// RemoveEventListener waits for events in flight, like vendored client does
+func (f *fakeCollectorDockerClient) RemoveEventListener(listener chan *docker.APIEvents) error {
+	f.events.Lock()
+	defer f.events.Unlock()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for i, l := range f.listeners {
+		if l == listener {
+			f.listeners = append(f.listeners[:i], f.listeners[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// emit sends event to every listener
+func (f *fakeCollectorDockerClient) emit(id, status string) {
+	f.emitAt(id, status, time.Time{})
+}
+
+// emitAt sends event that happened at specified time to every listener
+func (f *fakeCollectorDockerClient) emitAt(id, status string, at time.Time) {
+	e := &docker.APIEvents{ID: id, Status: status, Type: "container"}
+	if !at.IsZero() {
+		e.Time = at.Unix()
+		e.TimeNano = at.UnixNano()
+	}
+
+	f.events.RLock()
+	defer f.events.RUnlock()
+
+	f.mutex.Lock()
+	listeners := append([]chan<- *docker.APIEvents{}, f.listeners...)
+	f.inflight++
+	f.mutex.Unlock()
+
+	for _, l := range listeners {
+		l <- e
+	}
+
+	f.mutex.Lock()
+	f.inflight--
+	f.mutex.Unlock()
+}
+
+// disconnect closes every listener, like vendored client does on error
+func (f *fakeCollectorDockerClient) disconnect() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, l := range f.listeners {
+		close(l)
+	}
+
+	f.listeners = nil
+}
+
+func (f *fakeCollectorDockerClient) listening() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return len(f.listeners)
+}
+
+func (f *fakeCollectorDockerClient) setRunning(id string, running bool) {
+	f.mutex.Lock()
+	f.containers[id].State.Running = running
+	f.mutex.Unlock()
+}
+
+func (f *fakeCollectorDockerClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	containers := []docker.APIContainers{}
+	for id, container := range f.containers {
+		if container.State.Running {
+			containers = append(containers, docker.APIContainers{ID: id})
+		}
+	}
+
+	return containers, nil
+}
+
+type fakeEventWriter struct {
+	events chan Event
+}
+
+func (w fakeEventWriter) Write(s Stats) error {
+	return nil
+}
+
+func (w fakeEventWriter) WriteEvent(e Event) error {
+	w.events <- e
+	return nil
+}
+
+func TestCollectorIgnoresEventsOfUnmonitoredContainers(t *testing.T) {
+	client := newFakeCollectorDockerClient()
+	client.add("abc", "")
+
+	c := NewCollector(client, fakeEventWriter{events: make(chan Event, 10)}, 1, Options{})
+
+	for _, status := range []string{"kill", "die", "stop"} {
+		e := &docker.APIEvents{ID: "abc", Status: status, Type: "container"}
+
+		if status == "die" {
+			c.died(e)
+		}
+
+		c.event(e)
+	}
+
+	if n := client.inspected("abc"); n != 1 {
+		t.Errorf("expected unmonitored container to be inspected once, got %d", n)
+	}
+}
+
+func TestCollectorCrashStats(t *testing.T) {
+	client := newFakeCollectorDockerClient()
+	client.add("abc", "myapp")
+
+	c := NewCollector(client, fakeEventWriter{events: make(chan Event, 10)}, 1, Options{})
+
+	exited := func(code string) *docker.APIEvents {
+		e := &docker.APIEvents{ID: "abc", Status: "die", Type: "container"}
+		if code != "" {
+			e.Actor.Attributes = map[string]string{"exitCode": code}
+		}
+
+		return e
+	}
+
+	// container is already restarted and reports zero exit code on inspect
+	c.died(exited("1"))
+	c.died(exited("0"))
+	c.oomKilled(&docker.APIEvents{ID: "abc", Status: "oom", Type: "container"})
+	c.died(exited("137"))
+
+	client.mutex.Lock()
+	client.containers["abc"].State.ExitCode = 2
+	client.mutex.Unlock()
+
+	// exit code is inspected when event does not have it
+	c.died(exited(""))
+
+	tasks := c.taskStats(time.Now())
+	if len(tasks) != 1 {
+		t.Fatalf("expected crash stats of a single task, got %+v", tasks)
+	}
+
+	expected := CrashStats{OOMKills: 1, Failures: 3}
+	if tasks[0].App != "myapp" || tasks[0].Task != defaultTask || tasks[0].Crashes != expected {
+		t.Errorf("expected crash stats %+v of myapp.default, got %+v", expected, tasks[0])
+	}
+
+	// counters are kept while the task has known containers
+	if tasks := c.taskStats(time.Now().Add(2 * taskStatsTTL)); len(tasks) != 1 {
+		t.Errorf("expected crash stats of task with known container to be kept, got %+v", tasks)
+	}
+
+	c.forget("abc")
+
+	if tasks := c.taskStats(time.Now().Add(taskStatsTTL / 2)); len(tasks) != 1 {
+		t.Errorf("expected crash stats of task without containers to be kept for a while, got %+v", tasks)
+	}
+
+	if tasks := c.taskStats(time.Now().Add(4 * taskStatsTTL)); len(tasks) != 0 {
+		t.Errorf("expected crash stats of task without containers to be forgotten, got %+v", tasks)
+	}
+}
+
+type fakeTaskStatsWriter struct {
+	tasks chan TaskStats
+}
+
+func (w fakeTaskStatsWriter) Write(s Stats) error {
+	return nil
+}
+
+func (w fakeTaskStatsWriter) WriteTaskStats(t TaskStats) error {
+	w.tasks <- t
+	return nil
+}
+
+func TestCollectorWritesTaskStatsOnShutdown(t *testing.T) {
+	client := newFakeCollectorDockerClient()
+	client.add("abc", "myapp")
+	client.add("def", "myapp")
+
+	w := fakeTaskStatsWriter{tasks: make(chan TaskStats, 10)}
+
+	// interval is long enough for task stats not to be written before shutdown
+	c := NewCollector(client, w, 3600, Options{})
+
+	for _, id := range []string{"abc", "def"} {
+		if _, ok := c.container(id, true); !ok {
+			t.Fatalf("expected container %s to be monitored", id)
+		}
+	}
+
+	c.shutdown()
+
+	close(w.tasks)
+
+	tasks := []TaskStats{}
+	for task := range w.tasks {
+		tasks = append(tasks, task)
+	}
+
+	if len(tasks) != 1 || tasks[0].App != "myapp" || tasks[0].Task != defaultTask {
+		t.Errorf("expected task stats to be written once for both containers, got %+v", tasks)
+	}
+}
+
+func TestCollectorHealth(t *testing.T) {
+	client := newFakeCollectorDockerClient()
+	client.add("abc", "myapp")
+	client.health["abc"] = &containerHealth{Status: "unhealthy", FailingStreak: 3}
+
+	c := NewCollector(client, fakeEventWriter{events: make(chan Event, 10)}, 1, Options{})
+
+	state := func() ContainerState {
+		s := Stats{ID: "abc"}
+		c.annotate(&s)
+		return s.State
+	}
+
+	if _, ok := c.container("abc", true); !ok {
+		t.Fatal("expected container to be monitored")
+	}
+
+	if s := state(); s.Health != "unhealthy" || s.FailingStreak != 3 {
+		t.Errorf("expected health from inspection, got %+v", s)
+	}
+
+	// monitor reports failing streak that changes without events
+	refreshed := Stats{ID: "abc", State: ContainerState{Health: "unhealthy", FailingStreak: 5}}
+	c.annotate(&refreshed)
+
+	if s := state(); s.FailingStreak != 5 {
+		t.Errorf("expected failing streak from monitor to be remembered, got %+v", s)
+	}
+
+	c.updateState("abc", "health_status: healthy")
+
+	if s := state(); s.Health != "healthy" || s.FailingStreak != 0 {
+		t.Errorf("expected health from event, got %+v", s)
+	}
+
+	// health is not reported on inspection without details
+	client.mutex.Lock()
+	delete(client.health, "abc")
+	client.mutex.Unlock()
+
+	m, err := NewMonitor(client, "abc", 1, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.remember(m)
+
+	if s := state(); s.Health != "healthy" {
+		t.Errorf("expected known health to be kept, got %+v", s)
+	}
+}
+
+// eventually waits for condition to become true
+func eventually(t *testing.T, condition func() bool, message string) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCollectorRegister(t *testing.T) {
+	client := newFakeCollectorDockerClient()
+	client.add("abc", "myapp")
+
+	c := NewCollector(client, fakeEventWriter{events: make(chan Event, 10)}, 1, Options{})
+	ctx := context.Background()
+
+	monitor := func() *Monitor {
+		m, err := NewMonitor(client, "abc", 1, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return m
+	}
+
+	first := monitor()
+	if !c.register(ctx, first, false) {
+		t.Fatal("expected first monitor to be registered")
+	}
+
+	second := monitor()
+	if c.register(ctx, second, false) {
+		t.Errorf("expected second monitor not to be registered without replace")
+	}
+
+	if !c.register(ctx, second, true) {
+		t.Fatal("expected second monitor to replace the first one")
+	}
+
+	if !first.stopped() {
+		t.Errorf("expected replaced monitor to be stopped")
+	}
+
+	c.unregister(first)
+
+	if !c.isRegistered("abc") {
+		t.Errorf("expected replaced monitor not to unregister the new one")
+	}
+
+	c.stop("abc")
+
+	if !second.stopped() || c.isRegistered("abc") {
+		t.Errorf("expected stopped monitor to be stopped and unregistered")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if c.register(cancelled, monitor(), false) {
+		t.Errorf("expected no monitors to be registered after shutdown")
+	}
+}
+
+func TestCollectorReconcile(t *testing.T) {
+	client := newFakeCollectorDockerClient()
+	client.add("abc", "myapp")
+	client.add("def", "")
+
+	c := NewCollector(client, fakeEventWriter{events: make(chan Event, 10)}, 1, Options{})
+	ctx := context.Background()
+
+	c.reconcileOnce(ctx)
+
+	eventually(t, func() bool {
+		return c.isRegistered("abc")
+	}, "expected running container to be monitored")
+
+	eventually(t, func() bool {
+		return c.isIgnored("def")
+	}, "expected unlabeled container to be ignored")
+
+	client.setRunning("abc", false)
+	client.setRunning("def", false)
+
+	c.reconcileOnce(ctx)
+
+	if c.isRegistered("abc") {
+		t.Errorf("expected monitor of stopped container to be stopped")
+	}
+
+	if c.isIgnored("def") {
+		t.Errorf("expected stopped ignored container to be forgotten")
+	}
+
+	// reconciliation is disabled without positive interval
+	done := make(chan struct{})
+	go func() {
+		c.reconcile(ctx, 0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected disabled reconciliation to return")
+	}
+}
+
+func TestCollectorRun(t *testing.T) {
+	min, max := minReconnectDelay, maxReconnectDelay
+	minReconnectDelay, maxReconnectDelay = time.Millisecond, 10*time.Millisecond
+	defer func() {
+		minReconnectDelay, maxReconnectDelay = min, max
+	}()
+
+	client := newFakeCollectorDockerClient()
+	client.failures = 2
+	client.add("abc", "myapp")
+
+	c := NewCollector(client, fakeEventWriter{events: make(chan Event, 10)}, 1, Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx, 0)
+	}()
+
+	eventually(t, func() bool {
+		return client.listening() == 1 && c.isRegistered("abc")
+	}, "expected collector to connect after failures and monitor running container")
+
+	client.emit("abc", "stop")
+
+	eventually(t, func() bool {
+		return !c.isRegistered("abc")
+	}, "expected monitor to be stopped on stop event")
+
+	client.emit("abc", "start")
+
+	eventually(t, func() bool {
+		return c.isRegistered("abc")
+	}, "expected monitor to be started on start event")
+
+	client.disconnect()
+
+	eventually(t, func() bool {
+		return client.listening() == 1
+	}, "expected collector to reconnect to event stream")
+
+	client.mutex.Lock()
+	listens := client.listens
+	client.mutex.Unlock()
+
+	if listens != 4 {
+		t.Errorf("expected 4 attempts to listen for events, got %d", listens)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error on shutdown, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected collector to shut down")
+	}
+
+	if c.isRegistered("abc") {
+		t.Errorf("expected monitors to be stopped on shutdown")
+	}
+}
+
+func TestCollectorIgnoresStopEventsOfPreviousRun(t *testing.T) {
+	started := time.Unix(1460000000, 500000000)
+
+	client := newFakeCollectorDockerClient()
+	client.add("abc", "myapp")
+	client.containers["abc"].State.StartedAt = started
+
+	c := NewCollector(client, fakeEventWriter{events: make(chan Event, 10)}, 1, Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx, 0)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	eventually(t, func() bool {
+		return client.listening() == 1 && c.isRegistered("abc")
+	}, "expected collector to monitor running container")
+
+	running := func() bool {
+		s := Stats{ID: "abc"}
+		c.annotate(&s)
+		return s.State.Running
+	}
+
+	// restarted container is started before events of the previous run arrive
+	client.emit("abc", "start")
+	client.emitAt("abc", "die", started.Add(-time.Second))
+	client.emitAt("abc", "stop", started.Add(-time.Second))
+
+	// events are handled in order, so this one marks the end of previous ones
+	client.emitAt("abc", "pause", started.Add(time.Second))
+
+	eventually(t, func() bool {
+		known, _ := c.container("abc", false)
+		return known.state.Paused && c.isRegistered("abc")
+	}, "expected monitor of restarted container to keep running")
+
+	if !running() {
+		t.Errorf("expected restarted container to be running after die event of previous run")
+	}
+
+	client.emitAt("abc", "die", started.Add(2*time.Second))
+
+	eventually(t, func() bool {
+		return !c.isRegistered("abc") && !running()
+	}, "expected monitor to be stopped on die event of current run")
+}
+
+func TestCollectorRemoveListenerWithEventInFlight(t *testing.T) {
+	client := newFakeCollectorDockerClient()
+
+	c := NewCollector(client, fakeEventWriter{events: make(chan Event, 10)}, 1, Options{})
+
+	ch := make(chan *docker.APIEvents)
+	err := client.AddEventListener(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody reads the event, so it stays in flight
+	go client.emit("abc", "kill")
+
+	eventually(t, func() bool {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+
+		return client.inflight == 1
+	}, "expected event to be in flight")
+
+	removed := make(chan struct{})
+	go func() {
+		c.removeListener(ch)
+		close(removed)
+	}()
+
+	select {
+	case <-removed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected listener to be removed with event in flight")
+	}
+
+	if client.listening() != 0 {
+		t.Errorf("expected listener to be removed")
+	}
+}
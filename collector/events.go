@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// notifiedEvents are container events that are reported to EventWriter,
+// health_status events are reported as well
+var notifiedEvents = map[string]struct{}{
+	"die":     {},
+	"oom":     {},
+	"kill":    {},
+	"stop":    {},
+	"pause":   {},
+	"unpause": {},
+	"destroy": {},
+}
+
+// Event represents lifecycle event of a container for specific task
+type Event struct {
+	ID       string
+	App      string
+	Task     string
+	Status   string
+	ExitCode int
+	Time     time.Time
+}
+
+// EventWriter is implemented by stats writers
+// that are able to deliver container events
+type EventWriter interface {
+	WriteEvent(e Event) error
+}
+
+func isNotifiedEvent(status string) bool {
+	if strings.HasPrefix(status, "health_status") {
+		return true
+	}
+
+	_, ok := notifiedEvents[status]
+	return ok
+}
+
+func eventTime(e *docker.APIEvents) time.Time {
+	if e.TimeNano != 0 {
+		return time.Unix(0, e.TimeNano)
+	}
+
+	return time.Unix(e.Time, 0)
+}
+
+// eventBefore returns true if the event happened before the time,
+// docker before 1.10 reports event time only with seconds precision
+func eventBefore(e *docker.APIEvents, t time.Time) bool {
+	if e.TimeNano == 0 {
+		t = t.Truncate(time.Second)
+	}
+
+	return eventTime(e).Before(t)
+}
+
+// eventExitCode returns exit code of the container from event attributes,
+// ok is false if docker is too old to report it
+func eventExitCode(e *docker.APIEvents) (code int, ok bool) {
+	v, ok := e.Actor.Attributes["exitCode"]
+	if !ok {
+		return 0, false
+	}
+
+	code, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+
+	return code, true
+}
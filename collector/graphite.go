@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const graphiteTemplate = "%s%s.docker_stats.%s.%s.gauge.%s %s %d\n"
+
+// graphiteReconnectDelay is how long to wait before reconnecting to carbon
+const graphiteReconnectDelay = time.Second
+
+// graphiteCloseTimeout is how long to wait for buffered lines
+// to be delivered to carbon on close
+const graphiteCloseTimeout = 10 * time.Second
+
+// graphiteBatchSize is how many queued lines are written to carbon at once
+const graphiteBatchSize = 500
+
+// graphiteWriteTimeout is how long to wait for carbon to accept a batch,
+// carbon that stops reading is reconnected after that
+var graphiteWriteTimeout = 10 * time.Second
+
+// GraphiteWriter is responsible for writing data directly to carbon
+// in graphite plaintext protocol, producing the same metric names
+// as collectd with write_graphite plugin would produce
+type GraphiteWriter struct {
+	host   string
+	prefix string
+	addr   string
+	lines  chan string
+	closed chan struct{}
+}
+
+// NewGraphiteWriter creates new GraphiteWriter with specified hostname,
+// metric prefix, carbon address and the number of lines to buffer
+// in memory while carbon is unavailable
+func NewGraphiteWriter(host, prefix, addr string, size int) (*GraphiteWriter, error) {
+	if size < 1 {
+		return nil, errors.New("graphite buffer should hold at least one line")
+	}
+
+	w := &GraphiteWriter{
+		host:   host,
+		prefix: prefix,
+		addr:   addr,
+		lines:  make(chan string, size),
+		closed: make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Write queues stats for sending to carbon, dropping the oldest
+// queued lines if the buffer is full
+func (w *GraphiteWriter) Write(s Stats) error {
+	w.write(s.App, s.Task, s.Stats.Read.Unix(), s.Metrics())
+	return nil
+}
+
+// WriteTaskStats queues task crash counters for sending to carbon
+func (w *GraphiteWriter) WriteTaskStats(t TaskStats) error {
+	w.write(t.App, t.Task, t.Time.Unix(), t.Metrics())
+	return nil
+}
+
+func (w *GraphiteWriter) write(app, task string, t int64, metrics []Metric) {
+	for _, m := range metrics {
+		w.enqueue(fmt.Sprintf(graphiteTemplate, w.prefix, w.host, app, task, m.Name, m.Value, t))
+	}
+}
+
+// Close waits for buffered lines to be delivered to carbon,
+// stats cannot be written after the writer is closed
+func (w *GraphiteWriter) Close() error {
+	close(w.lines)
+
+	select {
+	case <-w.closed:
+		return nil
+	case <-time.After(graphiteCloseTimeout):
+		return fmt.Errorf("timed out delivering %d lines to carbon at %s", len(w.lines), w.addr)
+	}
+}
+
+func (w *GraphiteWriter) enqueue(line string) {
+	for {
+		select {
+		case w.lines <- line:
+			return
+		default:
+		}
+
+		select {
+		case <-w.lines:
+		default:
+		}
+	}
+}
+
+func (w *GraphiteWriter) run() {
+	pending := []string{}
+
+	for {
+		conn, err := net.Dial("tcp", w.addr)
+		if err != nil {
+			log.Printf("error connecting to carbon at %s: %s\n", w.addr, err)
+			time.Sleep(graphiteReconnectDelay)
+			continue
+		}
+
+		pending, err = w.send(conn, pending)
+		if err == nil {
+			conn.Close()
+			close(w.closed)
+			return
+		}
+
+		log.Printf("error writing to carbon at %s: %s\n", w.addr, err)
+
+		conn.Close()
+		time.Sleep(graphiteReconnectDelay)
+	}
+}
+
+// send writes lines to connection in batches until it fails or the writer
+// is closed, returning lines that were not written and should be retried
+// on the next connection
+func (w *GraphiteWriter) send(conn net.Conn, pending []string) ([]string, error) {
+	closed := false
+
+	for {
+		if len(pending) == 0 {
+			if closed {
+				return nil, nil
+			}
+
+			line, ok := <-w.lines
+			if !ok {
+				return nil, nil
+			}
+
+			pending = append(pending, line)
+		}
+
+	batch:
+		for !closed && len(pending) < graphiteBatchSize {
+			select {
+			case line, ok := <-w.lines:
+				if !ok {
+					closed = true
+					break batch
+				}
+
+				pending = append(pending, line)
+			default:
+				break batch
+			}
+		}
+
+		err := conn.SetWriteDeadline(time.Now().Add(graphiteWriteTimeout))
+		if err != nil {
+			return pending, err
+		}
+
+		_, err = io.WriteString(conn, strings.Join(pending, ""))
+		if err != nil {
+			return pending, err
+		}
+
+		pending = pending[:0]
+	}
+}
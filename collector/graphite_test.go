@@ -0,0 +1,179 @@
+package collector
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestGraphiteWriter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	w, err := NewGraphiteWriter("myhost", "collectd.", l.Addr().String(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := docker.Stats{Read: time.Unix(1460000000, 0)}
+	s.MemoryStats.Usage = 1024
+
+	stats := Stats{App: "myapp", Task: "mytask", Stats: s}
+
+	w.Write(stats)
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	expected := "collectd.myhost.docker_stats.myapp.mytask.gauge.memory.usage 1024 1460000000"
+
+	r := bufio.NewReader(conn)
+	for i := 0; i < len(stats.Metrics()); i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.TrimSpace(line) == expected {
+			return
+		}
+	}
+
+	t.Errorf("expected line %q was not received", expected)
+}
+
+func TestGraphiteWriterClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	w, err := NewGraphiteWriter("myhost", "collectd.", l.Addr().String(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := Stats{App: "myapp", Task: "mytask", Stats: docker.Stats{Read: time.Unix(1460000000, 0)}}
+
+	w.Write(stats)
+	w.Write(stats)
+
+	received := make(chan int)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- 0
+			return
+		}
+
+		defer conn.Close()
+
+		lines := 0
+
+		r := bufio.NewReader(conn)
+		for {
+			_, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+
+			lines++
+		}
+
+		received <- lines
+	}()
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := len(stats.Metrics()) * 2
+	if lines := <-received; lines != expected {
+		t.Errorf("expected %d lines to be delivered on close, got %d", expected, lines)
+	}
+}
+
+func TestGraphiteWriterDropsOldest(t *testing.T) {
+	w := &GraphiteWriter{lines: make(chan string, 2)}
+
+	w.enqueue("one")
+	w.enqueue("two")
+	w.enqueue("three")
+
+	if first := <-w.lines; first != "two" {
+		t.Errorf("expected oldest line to be dropped, got %q first", first)
+	}
+}
+
+func TestGraphiteWriterKeepsUnsentLines(t *testing.T) {
+	w := &GraphiteWriter{lines: make(chan string, 10)}
+
+	w.enqueue("one\n")
+	w.enqueue("two\n")
+
+	client, server := net.Pipe()
+	server.Close()
+
+	pending, err := w.send(client, nil)
+	if err == nil {
+		t.Fatal("expected error writing to closed connection")
+	}
+
+	if strings.Join(pending, "") != "one\ntwo\n" {
+		t.Errorf("expected unsent lines to be kept for retry, got %q", pending)
+	}
+}
+
+func TestGraphiteWriterWriteTimeout(t *testing.T) {
+	timeout := graphiteWriteTimeout
+	graphiteWriteTimeout = 50 * time.Millisecond
+	defer func() {
+		graphiteWriteTimeout = timeout
+	}()
+
+	w := &GraphiteWriter{lines: make(chan string, 10)}
+
+	w.enqueue("one\n")
+
+	// carbon that never reads from the connection
+	client, server := net.Pipe()
+	defer server.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := w.send(client, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected error writing to carbon that does not read")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected write to carbon that does not read to time out")
+	}
+}
+
+func TestGraphiteWriterBufferSize(t *testing.T) {
+	_, err := NewGraphiteWriter("myhost", "collectd.", "127.0.0.1:2003", 0)
+	if err == nil {
+		t.Errorf("expected error for empty buffer")
+	}
+}
@@ -0,0 +1,297 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const influxDBMeasurementPrefix = "docker_"
+
+var influxDBEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// influxDBClient makes sure that unresponsive influxdb
+// does not block writing stats indefinitely
+var influxDBClient = &http.Client{Timeout: 10 * time.Second}
+
+// influxDBRetryDelay is how long to wait before retrying failed write
+const influxDBRetryDelay = time.Second
+
+// influxDBCloseTimeout is how long to wait for buffered points
+// to be delivered to influxdb on close
+const influxDBCloseTimeout = 10 * time.Second
+
+// influxDBBatchSize is how many queued stats are written
+// to influxdb in a single http request
+const influxDBBatchSize = 100
+
+// InfluxDBWriter is responsible for writing data to influxdb
+// in line protocol over http or udp
+type InfluxDBWriter struct {
+	host   string
+	addr   string
+	send   func([]byte) error
+	batch  int
+	points chan []byte
+	closed chan struct{}
+}
+
+// NewInfluxDBWriter creates new InfluxDBWriter with specified hostname,
+// influxdb address, which is either udp://host:port or http write
+// endpoint like http://host:8086/write?db=docker, and the number
+// of stats to buffer in memory while influxdb is slow or unavailable
+func NewInfluxDBWriter(host string, addr string, size int) (*InfluxDBWriter, error) {
+	if size < 1 {
+		return nil, errors.New("influxdb buffer should hold at least one stats")
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &InfluxDBWriter{
+		host:   host,
+		addr:   addr,
+		points: make(chan []byte, size),
+		closed: make(chan struct{}),
+	}
+
+	switch u.Scheme {
+	case "udp":
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		// every stats are sent in a separate datagram
+		w.batch = 1
+		w.send = func(b []byte) error {
+			_, err := conn.Write(b)
+			return err
+		}
+	case "http", "https":
+		w.batch = influxDBBatchSize
+		w.send = func(b []byte) error {
+			return influxDBPost(addr, b)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported influxdb address scheme %q", u.Scheme)
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Write queues stats for sending to influxdb, dropping the oldest
+// queued stats if the buffer is full
+func (w *InfluxDBWriter) Write(s Stats) error {
+	w.enqueue(w.lines(s))
+	return nil
+}
+
+// Close waits for buffered points to be delivered to influxdb,
+// stats cannot be written after the writer is closed
+func (w *InfluxDBWriter) Close() error {
+	close(w.points)
+
+	select {
+	case <-w.closed:
+		return nil
+	case <-time.After(influxDBCloseTimeout):
+		return fmt.Errorf("timed out delivering %d stats to influxdb at %s", len(w.points), w.addr)
+	}
+}
+
+// WriteTaskStats queues task crash counters for sending to influxdb,
+// they are written without container tag
+func (w *InfluxDBWriter) WriteTaskStats(t TaskStats) error {
+	tags := map[string]string{
+		"app":  t.App,
+		"host": w.host,
+		"task": t.Task,
+	}
+
+	fields := []string{}
+	for _, m := range t.Metrics() {
+		fields = append(fields, influxDBField(strings.TrimPrefix(m.Name, "task."), m.Value))
+	}
+
+	sort.Strings(fields)
+
+	w.enqueue([]byte(fmt.Sprintf("%stask,%s %s %d\n", influxDBMeasurementPrefix, influxDBTags(tags), strings.Join(fields, ","), t.Time.UnixNano())))
+
+	return nil
+}
+
+func (w *InfluxDBWriter) enqueue(points []byte) {
+	for {
+		select {
+		case w.points <- points:
+			return
+		default:
+		}
+
+		select {
+		case <-w.points:
+		default:
+		}
+	}
+}
+
+// run sends queued points in batches, failed batch
+// is retried until it is delivered
+func (w *InfluxDBWriter) run() {
+	defer close(w.closed)
+
+	pending := [][]byte{}
+	closed := false
+
+	for {
+		if len(pending) == 0 {
+			if closed {
+				return
+			}
+
+			points, ok := <-w.points
+			if !ok {
+				return
+			}
+
+			pending = append(pending, points)
+		}
+
+	batch:
+		for !closed && len(pending) < w.batch {
+			select {
+			case points, ok := <-w.points:
+				if !ok {
+					closed = true
+					break batch
+				}
+
+				pending = append(pending, points)
+			default:
+				break batch
+			}
+		}
+
+		err := w.send(bytes.Join(pending, nil))
+		if err != nil {
+			log.Printf("error writing to influxdb at %s: %s\n", w.addr, err)
+			time.Sleep(influxDBRetryDelay)
+			continue
+		}
+
+		pending = pending[:0]
+	}
+}
+
+func (w *InfluxDBWriter) lines(s Stats) []byte {
+	fields := map[string][]string{}
+
+	metrics, groups := s.GroupedMetrics()
+
+	for _, m := range metrics {
+		parts := strings.SplitN(m.Name, ".", 2)
+		measurement := influxDBMeasurementPrefix + parts[0]
+		fields[measurement] = append(fields[measurement], influxDBField(parts[1], m.Value))
+	}
+
+	tags := map[string]string{
+		"app":       s.App,
+		"container": s.ID,
+		"host":      w.host,
+		"task":      s.Task,
+	}
+
+	measurements := make([]string, 0, len(fields))
+	for measurement := range fields {
+		measurements = append(measurements, measurement)
+	}
+
+	sort.Strings(measurements)
+
+	b := bytes.Buffer{}
+	t := s.Stats.Read.UnixNano()
+
+	for _, measurement := range measurements {
+		sort.Strings(fields[measurement])
+		fmt.Fprintf(&b, "%s,%s %s %d\n", measurement, influxDBTags(tags), strings.Join(fields[measurement], ","), t)
+	}
+
+	// instances are written as tags, measurements are different
+	// from totals, so summing over all series does not count twice
+	for _, g := range groups {
+		instance := map[string]string{g.Label: g.Instance}
+		for k, v := range tags {
+			instance[k] = v
+		}
+
+		points := make([]string, 0, len(g.Metrics))
+		for _, m := range g.Metrics {
+			points = append(points, influxDBField(m.Name, m.Value))
+		}
+
+		sort.Strings(points)
+
+		measurement := influxDBMeasurementPrefix + g.Prefix + "_" + g.Label
+		fmt.Fprintf(&b, "%s,%s %s %d\n", measurement, influxDBTags(instance), strings.Join(points, ","), t)
+	}
+
+	return b.Bytes()
+}
+
+// influxDBTags formats tags sorted by key, as influxdb prefers
+func influxDBTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, influxDBEscaper.Replace(k)+"="+influxDBEscaper.Replace(tags[k]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func influxDBField(name string, v MetricValue) string {
+	return influxDBEscaper.Replace(name) + "=" + influxDBValue(v)
+}
+
+// influxDBValue formats integer values as influxdb integers,
+// so fields do not change type to float
+func influxDBValue(v MetricValue) string {
+	if v.isFloat {
+		return v.String()
+	}
+
+	return v.String() + "i"
+}
+
+func influxDBPost(addr string, b []byte) error {
+	resp, err := influxDBClient.Post(addr, "text/plain", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected influxdb response status: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestInfluxDBWriter(t *testing.T) {
+	bodies := make(chan string, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	defer server.Close()
+
+	w, err := NewInfluxDBWriter("my host", server.URL+"/write?db=docker", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := docker.Stats{Read: time.Unix(1460000000, 0)}
+	s.CPUStats.CPUUsage.TotalUsage = 300
+	s.CPUStats.CPUUsage.UsageInUsermode = 100
+	s.CPUStats.CPUUsage.UsageInKernelmode = 200
+	s.Networks = map[string]docker.NetworkStats{
+		"eth0": {RxBytes: 100},
+	}
+
+	err = w.Write(Stats{ID: "abc", App: "myapp", Task: "mytask", Stats: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := <-bodies
+
+	interfaceLine := `docker_net_interface,app=myapp,container=abc,host=my\ host,interface=eth0,task=mytask `
+	if !strings.Contains(body, interfaceLine) || strings.Contains(body, "eth0.rx_bytes") {
+		t.Errorf("expected interface to be a tag in line starting with %q, got:\n%s", interfaceLine, body)
+	}
+
+	prefix := `docker_cpu,app=myapp,container=abc,host=my\ host,task=mytask `
+	suffix := " 1460000000000000000"
+
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		if !strings.HasSuffix(line, suffix) {
+			t.Errorf("expected line %q to end with %q", line, suffix)
+		}
+
+		fields := strings.Split(strings.TrimSuffix(strings.TrimPrefix(line, prefix), suffix), ",")
+		for _, e := range []string{"system=200i", "total=300i", "user=100i", "percent=0"} {
+			found := false
+			for _, f := range fields {
+				if f == e {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("expected field %s in line %q", e, line)
+			}
+		}
+
+		return
+	}
+
+	t.Errorf("expected line starting with %q in body:\n%s", prefix, body)
+}
+
+func TestInfluxDBWriterTimeout(t *testing.T) {
+	hung := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer server.Close()
+	defer close(hung)
+
+	client := influxDBClient
+	influxDBClient = &http.Client{Timeout: 50 * time.Millisecond}
+	defer func() {
+		influxDBClient = client
+	}()
+
+	err := influxDBPost(server.URL+"/write?db=docker", []byte("docker_cpu total=1i"))
+	if err == nil {
+		t.Errorf("expected error writing to unresponsive influxdb")
+	}
+}
+
+func TestInfluxDBWriterDropsOldest(t *testing.T) {
+	w := &InfluxDBWriter{points: make(chan []byte, 2)}
+
+	w.enqueue([]byte("one"))
+	w.enqueue([]byte("two"))
+	w.enqueue([]byte("three"))
+
+	if first := <-w.points; string(first) != "two" {
+		t.Errorf("expected oldest stats to be dropped, got %q first", first)
+	}
+}
+
+func TestInfluxDBWriterBatches(t *testing.T) {
+	sent := make(chan string, 10)
+
+	w := &InfluxDBWriter{
+		batch: 2,
+		send: func(b []byte) error {
+			sent <- string(b)
+			return nil
+		},
+		points: make(chan []byte, 10),
+		closed: make(chan struct{}),
+	}
+
+	w.enqueue([]byte("one\n"))
+	w.enqueue([]byte("two\n"))
+	w.enqueue([]byte("three\n"))
+
+	go w.run()
+
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(sent)
+
+	batches := []string{}
+	for b := range sent {
+		batches = append(batches, b)
+	}
+
+	expected := []string{"one\ntwo\n", "three\n"}
+	if strings.Join(batches, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected batches %q, got %q", expected, batches)
+	}
+}
@@ -0,0 +1,148 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// containerDetails are parts of container inspect response
+// that vendored docker client does not decode
+type containerDetails struct {
+	State struct {
+		Health *containerHealth
+	}
+	HostConfig struct {
+		MemoryReservation int64
+	}
+}
+
+// containerHealth is the state of container health check
+type containerHealth struct {
+	Status        string
+	FailingStreak int
+}
+
+// detailsInspector is implemented by docker clients that decode
+// container details from the same inspect response as the container
+type detailsInspector interface {
+	inspectWithDetails(id string) (*docker.Container, *containerDetails, error)
+}
+
+// inspectContainer inspects the container, details are empty
+// if docker client cannot decode them
+func inspectContainer(c MonitorDockerClient, id string) (*docker.Container, *containerDetails, error) {
+	if d, ok := c.(detailsInspector); ok {
+		return d.inspectWithDetails(id)
+	}
+
+	container, err := c.InspectContainer(id)
+	return container, &containerDetails{}, err
+}
+
+// DockerClient is docker client that decodes health check state
+// and memory reservation of containers in addition to what vendored
+// client decodes on inspect, collector reports them only with this client
+type DockerClient struct {
+	*docker.Client
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewDockerClient wraps docker client, inspect requests are made
+// with specified api version, which should be the version client
+// was created with or empty for the latest version, vendored client
+// does not expose its unix socket transport, so it is created here
+func NewDockerClient(client *docker.Client, apiVersion string) (*DockerClient, error) {
+	endpoint := client.Endpoint()
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "tcp://" + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	version := ""
+	if apiVersion != "" {
+		_, err := docker.NewAPIVersion(apiVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		version = "/v" + apiVersion
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+
+		return &DockerClient{
+			Client: client,
+			httpClient: &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return client.Dialer.Dial("unix", socket)
+					},
+				},
+			},
+			baseURL: "http://docker" + version,
+		}, nil
+	case "tcp", "http", "https":
+		scheme := "http"
+		if client.TLSConfig != nil {
+			scheme = "https"
+		}
+
+		return &DockerClient{
+			Client:     client,
+			httpClient: client.HTTPClient,
+			baseURL:    scheme + "://" + u.Host + version,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker endpoint %q", client.Endpoint())
+	}
+}
+
+func (c *DockerClient) inspectWithDetails(id string) (*docker.Container, *containerDetails, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/containers/" + id + "/json")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, &docker.NoSuchContainer{ID: id}
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, fmt.Errorf("unexpected docker response status: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	container := &docker.Container{}
+	err = json.Unmarshal(body, container)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	details := &containerDetails{}
+	err = json.Unmarshal(body, details)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return container, details, nil
+}
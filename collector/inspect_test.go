@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestDockerClientInspectWithDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1.24/containers/abc/json" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(`{
+			"Id": "abc",
+			"State": {
+				"Running": true,
+				"Health": {"Status": "unhealthy", "FailingStreak": 3}
+			},
+			"HostConfig": {
+				"MemoryReservation": 268435456
+			}
+		}`))
+	}))
+
+	defer server.Close()
+
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewDockerClient(client, "1.24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	container, details, err := c.inspectWithDetails("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if container.ID != "abc" || !container.State.Running {
+		t.Errorf("unexpected container: %+v", container)
+	}
+
+	health := details.State.Health
+	if health == nil || health.Status != "unhealthy" || health.FailingStreak != 3 {
+		t.Errorf("unexpected health: %+v", health)
+	}
+
+	if details.HostConfig.MemoryReservation != 268435456 {
+		t.Errorf("unexpected memory reservation: %d", details.HostConfig.MemoryReservation)
+	}
+
+	_, _, err = c.inspectWithDetails("def")
+	if _, ok := err.(*docker.NoSuchContainer); !ok {
+		t.Errorf("expected missing container error, got %v", err)
+	}
+}
+
+func TestNewDockerClient(t *testing.T) {
+	tests := map[string]string{
+		"unix:///var/run/docker.sock": "http://docker",
+		"tcp://127.0.0.1:2375":        "http://127.0.0.1:2375",
+	}
+
+	for endpoint, e := range tests {
+		client, err := docker.NewClient(endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := NewDockerClient(client, "")
+		if err != nil {
+			t.Errorf("expected endpoint %s to be supported, got %s", endpoint, err)
+			continue
+		}
+
+		if c.baseURL != e {
+			t.Errorf("expected base url %s for endpoint %s, got %s", e, endpoint, c.baseURL)
+		}
+	}
+
+	client, err := docker.NewClient("tcp://127.0.0.1:2375")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDockerClient(client, "latest"); err == nil {
+		t.Errorf("expected invalid api version to be rejected")
+	}
+}
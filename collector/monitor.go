@@ -1,9 +1,14 @@
 package collector
 
 import (
+	"context"
 	"errors"
+	"log"
 	"strings"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 )
@@ -31,6 +36,18 @@ var taskEnvLocationTrimPrefix = "COLLECTD_DOCKER_TASK_ENV_TRIM_PREFIX="
 
 const defaultTask = "default"
 
+// maxStatsRetries is how many times in a row failed stats stream
+// of a running container is retried before giving up
+const maxStatsRetries = 5
+
+// statsRetryDelay is the delay before the first retry of failed
+// stats stream, it is doubled for every next retry
+var statsRetryDelay = time.Second
+
+// statsRetryReset is how long stats stream should work
+// to start counting retries from scratch after failure
+const statsRetryReset = time.Minute
+
 // ErrNoNeedToMonitor is used to skip containers
 // that shouldn't be monitored by collectd
 var ErrNoNeedToMonitor = errors.New("container is not supposed to be monitored")
@@ -42,6 +59,18 @@ type MonitorDockerClient interface {
 	Stats(opts docker.StatsOptions) error
 }
 
+// Options configure what is reported in addition to docker stats,
+// with PerCPUMetrics cpu usage is reported for every core, with
+// ProcfsRoot network stats of containers without own network namespace
+// are read from host procfs mounted there, AggregatedMetrics are gauges
+// that are reported with min, max and avg over all stats received
+// since the previous report, like memory.usage.min for memory.usage
+type Options struct {
+	PerCPUMetrics     bool
+	ProcfsRoot        string
+	AggregatedMetrics []string
+}
+
 // Monitor is responsible for monitoring of a single container (task)
 type Monitor struct {
 	client   MonitorDockerClient
@@ -49,12 +78,19 @@ type Monitor struct {
 	app      string
 	task     string
 	interval int
+	options  Options
+	netPid   int
+	restarts int
+	state    ContainerState
+	limits   ContainerLimits
+	done     chan bool
+	once     sync.Once
 }
 
 // NewMonitor creates new monitor with specified docker client,
-// container id and stat updating interval
-func NewMonitor(c MonitorDockerClient, id string, interval int) (*Monitor, error) {
-	container, err := c.InspectContainer(id)
+// container id, stat updating interval and options
+func NewMonitor(c MonitorDockerClient, id string, interval int, options Options) (*Monitor, error) {
+	container, details, err := inspectContainer(c, id)
 	if err != nil {
 		return nil, err
 	}
@@ -66,41 +102,201 @@ func NewMonitor(c MonitorDockerClient, id string, interval int) (*Monitor, error
 
 	task := sanitizeForGraphite(extractTask(container))
 
+	netPid := 0
+	if options.ProcfsRoot != "" && container.HostConfig != nil && sharesNetwork(container.HostConfig.NetworkMode) {
+		netPid = container.State.Pid
+	}
+
 	return &Monitor{
 		client:   c,
 		id:       container.ID,
 		app:      app,
 		task:     task,
 		interval: interval,
+		options:  options,
+		netPid:   netPid,
+		restarts: container.RestartCount,
+		state:    extractState(container, details),
+		limits:   extractLimits(container, details),
+		done:     make(chan bool),
 	}, nil
 }
 
+// run streams stats of the container until it stops or context is done,
+// retrying failed stats stream with backoff while the container is still running
+func (m *Monitor) run(ctx context.Context, ch chan<- Stats) error {
+	defer m.stop()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.stop()
+		case <-m.done:
+		}
+	}()
+
+	retries := 0
+	delay := statsRetryDelay
+
+	for {
+		started := time.Now()
+
+		err := m.handle(ch)
+		if err == nil || m.stopped() {
+			return nil
+		}
+
+		if time.Since(started) > statsRetryReset {
+			retries = 0
+			delay = statsRetryDelay
+		}
+
+		if retries == maxStatsRetries {
+			return err
+		}
+
+		container, ierr := m.client.InspectContainer(m.id)
+		if ierr != nil || !container.State.Running {
+			return err
+		}
+
+		log.Printf("error handling container for app %s, retrying in %s: %s\n", m.app, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-m.done:
+			return nil
+		}
+
+		retries++
+		delay *= 2
+	}
+}
+
+// handle streams stats of the container, it returns
+// when every received stats are sent to the channel
 func (m *Monitor) handle(ch chan<- Stats) error {
 	in := make(chan *docker.Stats)
+	sent := make(chan struct{})
 
 	go func() {
-		i := 0
+		defer close(sent)
+
+		sampler := newSampler(m.interval)
+		aggregator := newAggregator(m.options.AggregatedMetrics)
+
 		for s := range in {
-			if i%m.interval != 0 {
-				i++
+			stats := Stats{
+				ID:     m.id,
+				App:    m.app,
+				Task:   m.task,
+				Stats:  *s,
+				Limits: m.limits,
+				perCPU: m.options.PerCPUMetrics,
+			}
+
+			aggregator.add(stats)
+
+			if !sampler.sample(s.Read) {
 				continue
 			}
 
-			ch <- Stats{
-				App:   m.app,
-				Task:  m.task,
-				Stats: *s,
+			if m.netPid != 0 {
+				networks, err := procNetworks(m.options.ProcfsRoot, m.netPid)
+				if err != nil {
+					log.Printf("error reading network stats for app %s: %s\n", m.app, err)
+				} else {
+					stats.Stats.Networks = networks
+				}
 			}
 
-			i++
+			m.refreshHealth(&stats)
+
+			stats.aggregates = aggregator.flush()
+
+			ch <- stats
 		}
 	}()
 
-	return m.client.Stats(docker.StatsOptions{
+	// Timeout is not set, because vendored client does not reset
+	// connection deadline after initial response for unix sockets
+	err := m.client.Stats(docker.StatsOptions{
 		ID:     m.id,
 		Stats:  in,
 		Stream: true,
+		Done:   m.done,
 	})
+
+	<-sent
+
+	return err
+}
+
+// refreshHealth adds health check state to stats of the container
+// that has health check, failing streak changes without events
+func (m *Monitor) refreshHealth(s *Stats) {
+	if m.state.Health == "" {
+		return
+	}
+
+	_, details, err := inspectContainer(m.client, m.id)
+	if err != nil {
+		log.Printf("error inspecting health of container for app %s: %s\n", m.app, err)
+		return
+	}
+
+	if details.State.Health != nil {
+		s.State.Health = details.State.Health.Status
+		s.State.FailingStreak = details.State.Health.FailingStreak
+	}
+}
+
+// stop interrupts stats stream of the monitor
+func (m *Monitor) stop() {
+	m.once.Do(func() {
+		close(m.done)
+	})
+}
+
+// stopped tells whether monitor was stopped with stop
+func (m *Monitor) stopped() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sampler picks stats to report once per interval, intervals are
+// aligned to wall clock, so every container reports at the same time
+type sampler struct {
+	interval int64
+	last     int64
+}
+
+func newSampler(interval int) *sampler {
+	if interval < 1 {
+		interval = 1
+	}
+
+	return &sampler{
+		interval: int64(interval),
+		last:     -1,
+	}
+}
+
+// sample tells whether stats read at specified time should be reported,
+// which happens for the first stats of every interval
+func (s *sampler) sample(t time.Time) bool {
+	slot := t.Unix() / s.interval
+	if slot == s.last {
+		return false
+	}
+
+	s.last = slot
+
+	return true
 }
 
 func extractApp(c *docker.Container) string {
@@ -139,6 +335,67 @@ func extractTask(c *docker.Container) string {
 	return task
 }
 
+func extractState(c *docker.Container, details *containerDetails) ContainerState {
+	state := ContainerState{
+		Running:    c.State.Running,
+		Paused:     c.State.Paused,
+		Restarting: c.State.Restarting,
+		StartedAt:  c.State.StartedAt,
+	}
+
+	if details.State.Health != nil {
+		state.Health = details.State.Health.Status
+		state.FailingStreak = details.State.Health.FailingStreak
+	}
+
+	return state
+}
+
+func extractLimits(c *docker.Container, details *containerDetails) ContainerLimits {
+	// memory reservation is reported in host config,
+	// but vendored client expects it in config
+	limits := ContainerLimits{
+		MemoryReservation: details.HostConfig.MemoryReservation,
+	}
+
+	if c.HostConfig != nil {
+		limits.CPUShares = c.HostConfig.CPUShares
+		limits.CPUQuota = c.HostConfig.CPUQuota
+		limits.CPUPeriod = c.HostConfig.CPUPeriod
+		limits.CPUSetCPUs = cpusetSize(c.HostConfig.CPUSetCPUs)
+		limits.MemorySwap = c.HostConfig.MemorySwap
+		limits.BlkioWeight = c.HostConfig.BlkioWeight
+	}
+
+	return limits
+}
+
+// cpusetSize returns the number of cpus in cpuset list like 0-3,8
+func cpusetSize(cpuset string) int {
+	size := 0
+
+	for _, part := range strings.Split(cpuset, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				continue
+			}
+		}
+
+		size += last - first + 1
+	}
+
+	return size
+}
+
 func extractMetadata(c *docker.Container, label, envPrefix, missing string) string {
 	if app, ok := c.Config.Labels[label]; ok {
 		return app
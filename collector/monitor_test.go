@@ -1,9 +1,11 @@
 package collector
 
 import (
+	"context"
 	"errors"
 	"github.com/fsouza/go-dockerclient"
 	"testing"
+	"time"
 )
 
 type fakeMonitorDockerClient struct {
@@ -156,7 +158,7 @@ func TestLabelExtraction(t *testing.T) {
 	}
 
 	for c, e := range tests {
-		m, err := NewMonitor(c, "", 1)
+		m, err := NewMonitor(c, "", 1, Options{})
 		if err != nil {
 			if err != e.err {
 				t.Errorf("expected error %q instead of %q for %#v", e.err, err, c)
@@ -180,3 +182,266 @@ func TestLabelExtraction(t *testing.T) {
 	}
 
 }
+
+func TestExtractLimits(t *testing.T) {
+	c := &docker.Container{
+		Config: &docker.Config{},
+		HostConfig: &docker.HostConfig{
+			CPUShares:   512,
+			CPUQuota:    50000,
+			CPUPeriod:   100000,
+			CPUSetCPUs:  "0-1,4",
+			MemorySwap:  -1,
+			BlkioWeight: 300,
+		},
+	}
+
+	details := &containerDetails{}
+	details.HostConfig.MemoryReservation = 1024
+
+	expected := ContainerLimits{
+		CPUShares:         512,
+		CPUQuota:          50000,
+		CPUPeriod:         100000,
+		CPUSetCPUs:        3,
+		MemoryReservation: 1024,
+		MemorySwap:        -1,
+		BlkioWeight:       300,
+	}
+
+	if limits := extractLimits(c, details); limits != expected {
+		t.Errorf("expected limits %+v, got %+v", expected, limits)
+	}
+
+	if limits := extractLimits(&docker.Container{}, &containerDetails{}); limits != (ContainerLimits{}) {
+		t.Errorf("expected no limits without config, got %+v", limits)
+	}
+}
+
+func TestCpusetSize(t *testing.T) {
+	tests := map[string]int{
+		"":          0,
+		"0":         1,
+		"0-3":       4,
+		"0-3,8":     5,
+		"0-1,4-5,7": 5,
+	}
+
+	for cpuset, e := range tests {
+		if size := cpusetSize(cpuset); size != e {
+			t.Errorf("expected cpuset %q to have %d cpus, got %d", cpuset, e, size)
+		}
+	}
+}
+
+func TestSampler(t *testing.T) {
+	s := newSampler(10)
+
+	base := time.Unix(1460000000, 0)
+
+	tests := []struct {
+		offset time.Duration
+		sample bool
+	}{
+		{3 * time.Second, true},
+		{4 * time.Second, false},
+		{9*time.Second + 900*time.Millisecond, false},
+		{10 * time.Second, true},
+		{11 * time.Second, false},
+		{25 * time.Second, true},
+		{29 * time.Second, false},
+		{32 * time.Second, true},
+	}
+
+	for _, test := range tests {
+		if sample := s.sample(base.Add(test.offset)); sample != test.sample {
+			t.Errorf("expected sample to be %v at %s, got %v", test.sample, test.offset, sample)
+		}
+	}
+}
+
+type flakyMonitorDockerClient struct {
+	running bool
+	calls   int
+}
+
+func (f *flakyMonitorDockerClient) InspectContainer(id string) (*docker.Container, error) {
+	return &docker.Container{
+		ID: id,
+		Config: &docker.Config{
+			Labels: map[string]string{appLabel: "myapp"},
+		},
+		State: docker.State{Running: f.running},
+	}, nil
+}
+
+func (f *flakyMonitorDockerClient) Stats(opts docker.StatsOptions) error {
+	close(opts.Stats)
+	f.calls++
+	return errors.New("stats stream is broken")
+}
+
+func TestMonitorRetries(t *testing.T) {
+	statsRetryDelay = time.Millisecond
+	defer func() {
+		statsRetryDelay = time.Second
+	}()
+
+	tests := map[bool]int{
+		true:  maxStatsRetries + 1,
+		false: 1,
+	}
+
+	for running, calls := range tests {
+		c := &flakyMonitorDockerClient{running: running}
+
+		m, err := NewMonitor(c, "abc", 1, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.run(context.Background(), make(chan Stats))
+		if err == nil {
+			t.Errorf("expected error after retries with running=%v", running)
+		}
+
+		if c.calls != calls {
+			t.Errorf("expected %d stats calls with running=%v, got %d", calls, running, c.calls)
+		}
+	}
+}
+
+type streamingMonitorDockerClient struct {
+	stats []docker.Stats
+}
+
+func (f streamingMonitorDockerClient) InspectContainer(id string) (*docker.Container, error) {
+	return &docker.Container{
+		ID: id,
+		Config: &docker.Config{
+			Labels: map[string]string{appLabel: "myapp"},
+		},
+	}, nil
+}
+
+func (f streamingMonitorDockerClient) Stats(opts docker.StatsOptions) error {
+	defer close(opts.Stats)
+
+	for i := range f.stats {
+		opts.Stats <- &f.stats[i]
+	}
+
+	return nil
+}
+
+func TestMonitorOptions(t *testing.T) {
+	base := time.Unix(1460000000, 0)
+
+	client := streamingMonitorDockerClient{}
+	for i, usage := range []uint64{100, 300, 200} {
+		s := docker.Stats{Read: base.Add(time.Duration(i) * 600 * time.Millisecond)}
+		s.MemoryStats.Usage = usage
+		s.CPUStats.CPUUsage.PercpuUsage = []uint64{usage}
+
+		client.stats = append(client.stats, s)
+	}
+
+	tests := map[bool]Options{
+		false: {},
+		true:  {PerCPUMetrics: true, AggregatedMetrics: []string{"memory.usage"}},
+	}
+
+	for enabled, options := range tests {
+		m, err := NewMonitor(client, "abc", 1, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ch := make(chan Stats, 10)
+
+		err = m.handle(ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		close(ch)
+
+		stats := []Stats{}
+		for s := range ch {
+			stats = append(stats, s)
+		}
+
+		if len(stats) != 2 {
+			t.Fatalf("expected stats to be reported once per interval, got %d", len(stats))
+		}
+
+		values := metricValues(stats[1])
+
+		_, perCPU := values["cpu.0.total"]
+		_, aggregated := values["memory.usage.max"]
+
+		if perCPU != enabled || aggregated != enabled {
+			t.Errorf("expected per cpu and aggregated metrics to be reported with %+v, got %v", options, values)
+		}
+
+		if enabled && values["memory.usage.max"] != 300 {
+			t.Errorf("expected memory.usage.max to be 300, got %v", values["memory.usage.max"])
+		}
+	}
+}
+
+type healthMonitorDockerClient struct {
+	streamingMonitorDockerClient
+	inspects int
+}
+
+func (f *healthMonitorDockerClient) inspectWithDetails(id string) (*docker.Container, *containerDetails, error) {
+	container, err := f.InspectContainer(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f.inspects++
+
+	details := &containerDetails{}
+	details.State.Health = &containerHealth{Status: "unhealthy", FailingStreak: f.inspects}
+
+	return container, details, nil
+}
+
+func TestMonitorRefreshesHealth(t *testing.T) {
+	base := time.Unix(1460000000, 0)
+
+	client := &healthMonitorDockerClient{}
+	for i := 0; i < 3; i++ {
+		client.stats = append(client.stats, docker.Stats{Read: base.Add(time.Duration(i) * 600 * time.Millisecond)})
+	}
+
+	m, err := NewMonitor(client, "abc", 1, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan Stats, 10)
+
+	err = m.handle(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(ch)
+
+	streaks := []int{}
+	for s := range ch {
+		if s.State.Health != "unhealthy" {
+			t.Errorf("expected health to be reported, got %+v", s.State)
+		}
+
+		streaks = append(streaks, s.State.FailingStreak)
+	}
+
+	// first inspection happens when monitor is created
+	if len(streaks) != 2 || streaks[0] != 2 || streaks[1] != 3 {
+		t.Errorf("expected failing streak to be refreshed on every interval, got %v", streaks)
+	}
+}
@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// sharesNetwork tells whether container with specified network mode
+// uses network namespace of the host or another container,
+// docker does not report network stats for such containers
+func sharesNetwork(mode string) bool {
+	return mode == "host" || strings.HasPrefix(mode, "container:")
+}
+
+// procNetworks reads network stats of every interface except loopback
+// from network namespace of the process with specified pid
+func procNetworks(root string, pid int) (map[string]docker.NetworkStats, error) {
+	f, err := os.Open(path.Join(root, strconv.Itoa(pid), "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return parseNetDev(f)
+}
+
+// parseNetDev parses network stats in /proc/net/dev format
+func parseNetDev(r io.Reader) (map[string]docker.NetworkStats, error) {
+	networks := map[string]docker.NetworkStats{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name := strings.TrimSpace(parts[0])
+		if name == "lo" {
+			continue
+		}
+
+		fields := strings.Fields(parts[1])
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("unexpected number of fields for interface %s: %d", name, len(fields))
+		}
+
+		values := make([]uint64, 16)
+		for i := range values {
+			v, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			values[i] = v
+		}
+
+		networks[name] = docker.NetworkStats{
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxErrors:  values[2],
+			RxDropped: values[3],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxErrors:  values[10],
+			TxDropped: values[11],
+		}
+	}
+
+	return networks, scanner.Err()
+}
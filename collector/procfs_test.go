@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+const netDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 2000000    3000    1    2    0     0          0         0   400000    5000    3    4    0     0       0          0
+`
+
+func TestProcNetworks(t *testing.T) {
+	root, err := ioutil.TempDir("", "procfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	err = os.MkdirAll(path.Join(root, "123", "net"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path.Join(root, "123", "net", "dev"), []byte(netDev), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	networks, err := procNetworks(root, 123)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := networks["lo"]; ok {
+		t.Errorf("expected loopback interface to be skipped")
+	}
+
+	eth0, ok := networks["eth0"]
+	if !ok {
+		t.Fatalf("expected eth0 interface, got %#v", networks)
+	}
+
+	if eth0.RxBytes != 2000000 || eth0.RxPackets != 3000 || eth0.RxErrors != 1 || eth0.RxDropped != 2 {
+		t.Errorf("unexpected rx stats for eth0: %#v", eth0)
+	}
+
+	if eth0.TxBytes != 400000 || eth0.TxPackets != 5000 || eth0.TxErrors != 3 || eth0.TxDropped != 4 {
+		t.Errorf("unexpected tx stats for eth0: %#v", eth0)
+	}
+}
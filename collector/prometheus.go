@@ -0,0 +1,183 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const prometheusMetricPrefix = "docker_stats_"
+
+// prometheusInvalidChars matches characters that are not allowed in metric names
+var prometheusInvalidChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+
+// PrometheusWriter keeps the latest stats for every container
+// and serves them in prometheus text exposition format
+type PrometheusWriter struct {
+	host  string
+	ttl   time.Duration
+	mutex sync.Mutex
+	stats map[string]Stats
+	tasks map[string]TaskStats
+}
+
+// NewPrometheusWriter creates new PrometheusWriter with specified hostname,
+// stats that were not updated for longer than ttl are not exposed
+func NewPrometheusWriter(host string, ttl time.Duration) *PrometheusWriter {
+	return &PrometheusWriter{
+		host:  host,
+		ttl:   ttl,
+		stats: map[string]Stats{},
+		tasks: map[string]TaskStats{},
+	}
+}
+
+// Write remembers stats to be exposed on the next scrape
+func (w *PrometheusWriter) Write(s Stats) error {
+	w.mutex.Lock()
+	w.stats[s.ID] = s
+	w.mutex.Unlock()
+
+	return nil
+}
+
+// WriteTaskStats remembers task crash counters to be exposed on the next scrape
+func (w *PrometheusWriter) WriteTaskStats(t TaskStats) error {
+	w.mutex.Lock()
+	w.tasks[t.App+"."+t.Task] = t
+	w.mutex.Unlock()
+
+	return nil
+}
+
+// ServeHTTP writes the latest stats of all containers
+func (w *PrometheusWriter) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	b := bufio.NewWriter(rw)
+	w.writeMetrics(b, time.Now())
+	b.Flush()
+}
+
+func (w *PrometheusWriter) writeMetrics(b *bufio.Writer, now time.Time) {
+	values := map[string][]string{}
+	kinds := map[string]MetricKind{}
+
+	stats, tasks := w.current(now)
+
+	// task counters are exposed without container id,
+	// so they are not counted for every container
+	for _, t := range tasks {
+		labels := fmt.Sprintf(
+			"app=%s,task=%s,host=%s",
+			prometheusLabelValue(t.App),
+			prometheusLabelValue(t.Task),
+			prometheusLabelValue(w.host),
+		)
+
+		for _, m := range t.Metrics() {
+			name := prometheusMetricName(m.Name)
+			kinds[name] = m.Kind
+			values[name] = append(values[name], fmt.Sprintf("%s{%s} %s\n", name, labels, m.Value))
+		}
+	}
+
+	for _, s := range stats {
+		labels := fmt.Sprintf(
+			"app=%s,task=%s,host=%s,container_id=%s",
+			prometheusLabelValue(s.App),
+			prometheusLabelValue(s.Task),
+			prometheusLabelValue(w.host),
+			prometheusLabelValue(s.ID),
+		)
+
+		metrics, groups := s.GroupedMetrics()
+
+		for _, m := range metrics {
+			name := prometheusMetricName(m.Name)
+			kinds[name] = m.Kind
+			values[name] = append(values[name], fmt.Sprintf("%s{%s} %s\n", name, labels, m.Value))
+		}
+
+		// instances are exposed as labels, metric names are different
+		// from totals, so summing over all series does not count twice
+		for _, g := range groups {
+			instance := fmt.Sprintf("%s,%s=%s", labels, g.Label, prometheusLabelValue(g.Instance))
+
+			for _, m := range g.Metrics {
+				name := prometheusMetricName(g.Prefix + "." + g.Label + "." + m.Name)
+				kinds[name] = m.Kind
+				values[name] = append(values[name], fmt.Sprintf("%s{%s} %s\n", name, instance, m.Value))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(b, "# TYPE %s %s\n", name, prometheusType(kinds[name]))
+
+		sort.Strings(values[name])
+		for _, line := range values[name] {
+			b.WriteString(line)
+		}
+	}
+}
+
+// current returns stats and task stats that are not expired,
+// forgetting expired ones
+func (w *PrometheusWriter) current(now time.Time) ([]Stats, []TaskStats) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	stats := make([]Stats, 0, len(w.stats))
+	for id, s := range w.stats {
+		if now.Sub(s.Stats.Read) > w.ttl {
+			delete(w.stats, id)
+			continue
+		}
+
+		stats = append(stats, s)
+	}
+
+	tasks := make([]TaskStats, 0, len(w.tasks))
+	for key, t := range w.tasks {
+		if now.Sub(t.Time) > w.ttl {
+			delete(w.tasks, key)
+			continue
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	return stats, tasks
+}
+
+func prometheusMetricName(k string) string {
+	return prometheusMetricPrefix + prometheusInvalidChars.ReplaceAllString(k, "_")
+}
+
+func prometheusType(kind MetricKind) string {
+	if kind == Counter {
+		return "counter"
+	}
+
+	return "gauge"
+}
+
+func prometheusLabelValue(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, "\n", `\n`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return `"` + v + `"`
+}
@@ -0,0 +1,78 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestPrometheusWriter(t *testing.T) {
+	now := time.Now()
+
+	w := NewPrometheusWriter("myhost", time.Minute)
+
+	fresh := docker.Stats{Read: now}
+	fresh.MemoryStats.Usage = 1024
+	fresh.Networks = map[string]docker.NetworkStats{
+		"br-1a2b3c": {RxBytes: 100},
+	}
+
+	stale := docker.Stats{Read: now.Add(-time.Hour)}
+	stale.MemoryStats.Usage = 2048
+
+	w.Write(Stats{ID: "abc", App: "my\"app", Task: "mytask", Stats: fresh})
+	w.Write(Stats{ID: "def", App: "oldapp", Task: "oldtask", Stats: stale})
+	w.WriteTaskStats(TaskStats{App: "myapp", Task: "mytask", Time: now, Crashes: CrashStats{Restarts: 2}})
+	w.WriteTaskStats(TaskStats{App: "oldapp", Task: "oldtask", Time: now.Add(-time.Hour)})
+
+	buf := bytes.Buffer{}
+	b := bufio.NewWriter(&buf)
+	w.writeMetrics(b, now)
+	b.Flush()
+
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE docker_stats_memory_usage gauge\n",
+		"# TYPE docker_stats_cpu_total counter\n",
+		"# TYPE docker_stats_net_interface_rx_bytes counter\n",
+		`docker_stats_memory_usage{app="my\"app",task="mytask",host="myhost",container_id="abc"} 1024` + "\n",
+		`docker_stats_net_rx_bytes{app="my\"app",task="mytask",host="myhost",container_id="abc"} 100` + "\n",
+		`docker_stats_net_interface_rx_bytes{app="my\"app",task="mytask",host="myhost",container_id="abc",interface="br-1a2b3c"} 100` + "\n",
+		"# TYPE docker_stats_task_restarts counter\n",
+		`docker_stats_task_restarts{app="myapp",task="mytask",host="myhost"} 2` + "\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("expected %q in output:\n%s", e, out)
+		}
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		name := strings.Fields(strings.TrimPrefix(line, "# TYPE "))[0]
+		if i := strings.Index(name, "{"); i != -1 {
+			name = name[:i]
+		}
+
+		if prometheusInvalidChars.MatchString(name) {
+			t.Errorf("invalid metric name %q in line %q", name, line)
+		}
+	}
+
+	if strings.Contains(out, "oldapp") {
+		t.Errorf("expected stale stats to be expired, got:\n%s", out)
+	}
+
+	if _, ok := w.stats["def"]; ok {
+		t.Errorf("expected stale stats to be forgotten")
+	}
+
+	if _, ok := w.tasks["oldapp.oldtask"]; ok {
+		t.Errorf("expected stale task stats to be forgotten")
+	}
+}
@@ -1,10 +1,469 @@
 package collector
 
-import "github.com/fsouza/go-dockerclient"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
 
 // Stats represents singe stat from docker stats api for specific task
 type Stats struct {
-	App   string
-	Task  string
-	Stats docker.Stats
+	ID     string
+	App    string
+	Task   string
+	Stats  docker.Stats
+	State  ContainerState
+	Limits ContainerLimits
+
+	aggregates []Metric
+	perCPU     bool
+}
+
+// ContainerLimits represents resource limits configured for the container,
+// zero value means that the limit is not set, memory swap is -1
+// if swap is unlimited
+type ContainerLimits struct {
+	CPUShares         int64
+	CPUQuota          int64
+	CPUPeriod         int64
+	CPUSetCPUs        int
+	MemoryReservation int64
+	MemorySwap        int64
+	BlkioWeight       int64
+}
+
+// ContainerState represents state of the container as reported by docker,
+// health is updated from health_status events between inspections
+type ContainerState struct {
+	Running       bool
+	Paused        bool
+	Restarting    bool
+	StartedAt     time.Time
+	Health        string
+	FailingStreak int
+}
+
+// MetricKind tells whether metric value is instantaneous
+// or monotonically increasing
+type MetricKind int
+
+// Gauge is an instantaneous value, Counter is a cumulative one
+const (
+	Gauge MetricKind = iota
+	Counter
+)
+
+// Metric is a single named value extracted from stats
+type Metric struct {
+	Name  string
+	Kind  MetricKind
+	Value MetricValue
+}
+
+// MetricValue is either an integer value or a float value of derived
+// ratio, integer values are kept integral, so large counters
+// do not lose precision
+type MetricValue struct {
+	integer int64
+	float   float64
+	isFloat bool
+}
+
+func intValue(v int64) MetricValue {
+	return MetricValue{integer: v}
+}
+
+func uintValue(v uint64) MetricValue {
+	return MetricValue{integer: int64(v)}
+}
+
+func floatValue(v float64) MetricValue {
+	return MetricValue{float: v, isFloat: true}
+}
+
+// IsFloat tells whether value is a float value of derived ratio
+func (v MetricValue) IsFloat() bool {
+	return v.isFloat
+}
+
+// Int returns integer value, float values are truncated
+func (v MetricValue) Int() int64 {
+	if v.isFloat {
+		return int64(v.float)
+	}
+
+	return v.integer
+}
+
+// Float returns value as float for calculations
+func (v MetricValue) Float() float64 {
+	if v.isFloat {
+		return v.float
+	}
+
+	return float64(v.integer)
+}
+
+// String formats value without exponent
+func (v MetricValue) String() string {
+	if v.isFloat {
+		return strconv.FormatFloat(v.float, 'f', -1, 64)
+	}
+
+	return strconv.FormatInt(v.integer, 10)
+}
+
+// MetricGroup is a group of metrics of a single instance of a resource,
+// like network interface or block device, backends without labels
+// get instance as a part of metric name, like net.eth0.rx_bytes
+type MetricGroup struct {
+	Prefix   string
+	Label    string
+	Instance string
+	Metrics  []Metric
+}
+
+// flatten returns metrics of the group with instance in metric names
+func (g MetricGroup) flatten() []Metric {
+	metrics := make([]Metric, 0, len(g.Metrics))
+
+	for _, m := range g.Metrics {
+		m.Name = g.Prefix + "." + sanitizeForGraphite(g.Instance) + "." + m.Name
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
+
+// Metrics returns flat list of metrics extracted from stats,
+// including derived and aggregated ones
+func (s Stats) Metrics() []Metric {
+	metrics, groups := s.GroupedMetrics()
+
+	for _, g := range groups {
+		metrics = append(metrics, g.flatten()...)
+	}
+
+	return metrics
+}
+
+// GroupedMetrics returns metrics extracted from stats, metrics of
+// cpu cores, network interfaces and block devices are returned in groups
+func (s Stats) GroupedMetrics() ([]Metric, []MetricGroup) {
+	groups := []MetricGroup{}
+
+	metrics := []Metric{
+		{"cpu.user", Counter, uintValue(s.Stats.CPUStats.CPUUsage.UsageInUsermode)},
+		{"cpu.system", Counter, uintValue(s.Stats.CPUStats.CPUUsage.UsageInKernelmode)},
+		{"cpu.total", Counter, uintValue(s.Stats.CPUStats.CPUUsage.TotalUsage)},
+
+		{"cpu.periods", Counter, uintValue(s.Stats.CPUStats.ThrottlingData.Periods)},
+		{"cpu.throttled_periods", Counter, uintValue(s.Stats.CPUStats.ThrottlingData.ThrottledPeriods)},
+		{"cpu.throttled_time", Counter, uintValue(s.Stats.CPUStats.ThrottlingData.ThrottledTime)},
+
+		{"memory.limit", Gauge, uintValue(s.Stats.MemoryStats.Limit)},
+		{"memory.max", Gauge, uintValue(s.Stats.MemoryStats.MaxUsage)},
+		{"memory.usage", Gauge, uintValue(s.Stats.MemoryStats.Usage)},
+
+		{"memory.active_anon", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalActiveAnon)},
+		{"memory.active_file", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalActiveFile)},
+		{"memory.cache", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalCache)},
+		{"memory.inactive_anon", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalInactiveAnon)},
+		{"memory.inactive_file", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalInactiveFile)},
+		{"memory.mapped_file", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalMappedFile)},
+		{"memory.pg_fault", Counter, uintValue(s.Stats.MemoryStats.Stats.TotalPgfault)},
+		{"memory.pg_in", Counter, uintValue(s.Stats.MemoryStats.Stats.TotalPgpgin)},
+		{"memory.pg_out", Counter, uintValue(s.Stats.MemoryStats.Stats.TotalPgpgout)},
+		{"memory.rss", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalRss)},
+		{"memory.rss_huge", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalRssHuge)},
+		{"memory.unevictable", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalUnevictable)},
+		{"memory.writeback", Gauge, uintValue(s.Stats.MemoryStats.Stats.TotalWriteback)},
+	}
+
+	metrics = append(metrics, s.memoryMetrics()...)
+
+	metrics = append(metrics, s.stateMetrics()...)
+	metrics = append(metrics, s.limitsMetrics()...)
+
+	if len(s.Stats.Networks) > 0 {
+		network := docker.NetworkStats{}
+		names := make([]string, 0, len(s.Stats.Networks))
+
+		for name, n := range s.Stats.Networks {
+			network.RxBytes += n.RxBytes
+			network.RxDropped += n.RxDropped
+			network.RxErrors += n.RxErrors
+			network.RxPackets += n.RxPackets
+
+			network.TxBytes += n.TxBytes
+			network.TxDropped += n.TxDropped
+			network.TxErrors += n.TxErrors
+			network.TxPackets += n.TxPackets
+
+			names = append(names, name)
+		}
+
+		metrics = append(metrics, networkMetrics("net.", network)...)
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			groups = append(groups, MetricGroup{
+				Prefix:   "net",
+				Label:    "interface",
+				Instance: name,
+				Metrics:  networkMetrics("", s.Stats.Networks[name]),
+			})
+		}
+	} else if s.Stats.Network != (docker.NetworkStats{}) {
+		// docker before 1.9 reports only a single interface
+		metrics = append(metrics, networkMetrics("net.", s.Stats.Network)...)
+	}
+
+	metrics = append(metrics, s.cpuUsageMetrics()...)
+
+	if s.perCPU {
+		for i, usage := range s.Stats.CPUStats.CPUUsage.PercpuUsage {
+			groups = append(groups, MetricGroup{
+				Prefix:   "cpu",
+				Label:    "cpu",
+				Instance: strconv.Itoa(i),
+				Metrics:  []Metric{{"total", Counter, uintValue(usage)}},
+			})
+		}
+	}
+
+	metrics = append(metrics, s.blkioMetrics()...)
+	groups = append(groups, s.blkioDeviceMetrics()...)
+
+	metrics = append(metrics, s.aggregates...)
+
+	return metrics, groups
+}
+
+func networkMetrics(prefix string, n docker.NetworkStats) []Metric {
+	return []Metric{
+		{prefix + "rx_bytes", Counter, uintValue(n.RxBytes)},
+		{prefix + "rx_dropped", Counter, uintValue(n.RxDropped)},
+		{prefix + "rx_errors", Counter, uintValue(n.RxErrors)},
+		{prefix + "rx_packets", Counter, uintValue(n.RxPackets)},
+
+		{prefix + "tx_bytes", Counter, uintValue(n.TxBytes)},
+		{prefix + "tx_dropped", Counter, uintValue(n.TxDropped)},
+		{prefix + "tx_errors", Counter, uintValue(n.TxErrors)},
+		{prefix + "tx_packets", Counter, uintValue(n.TxPackets)},
+	}
+}
+
+// limitsMetrics returns configured resource limits,
+// cpu quota is also reported as a number of cores
+func (s Stats) limitsMetrics() []Metric {
+	l := s.Limits
+
+	cpus := 0.0
+	if l.CPUQuota > 0 && l.CPUPeriod > 0 {
+		cpus = float64(l.CPUQuota) / float64(l.CPUPeriod)
+	}
+
+	return []Metric{
+		{"limits.cpu_shares", Gauge, intValue(l.CPUShares)},
+		{"limits.cpu_quota", Gauge, intValue(l.CPUQuota)},
+		{"limits.cpu_period", Gauge, intValue(l.CPUPeriod)},
+		{"limits.cpus", Gauge, floatValue(cpus)},
+		{"limits.cpuset_cpus", Gauge, intValue(int64(l.CPUSetCPUs))},
+		{"limits.memory_reservation", Gauge, intValue(l.MemoryReservation)},
+		{"limits.memory_swap", Gauge, intValue(l.MemorySwap)},
+		{"limits.blkio_weight", Gauge, intValue(l.BlkioWeight)},
+	}
+}
+
+// stateMetrics returns container state, health and uptime
+func (s Stats) stateMetrics() []Metric {
+	state := 0
+	switch {
+	case s.State.Restarting:
+		state = 3
+	case s.State.Paused:
+		state = 2
+	case s.State.Running:
+		state = 1
+	}
+
+	health := 0
+	switch s.State.Health {
+	case "starting":
+		health = 1
+	case "healthy":
+		health = 2
+	case "unhealthy":
+		health = 3
+	}
+
+	uptime := int64(0)
+	if s.State.Running && !s.State.StartedAt.IsZero() && s.Stats.Read.After(s.State.StartedAt) {
+		uptime = int64(s.Stats.Read.Sub(s.State.StartedAt) / time.Second)
+	}
+
+	return []Metric{
+		{"container.state", Gauge, intValue(int64(state))},
+		{"container.health", Gauge, intValue(int64(health))},
+		{"container.health_failing_streak", Gauge, intValue(int64(s.State.FailingStreak))},
+		{"container.uptime", Gauge, intValue(uptime)},
+	}
+}
+
+// memoryMetrics returns memory metrics in addition to hierarchical
+// breakdown: failures, swap, major faults, limits, working set
+// and local breakdown that does not include child cgroups
+func (s Stats) memoryMetrics() []Metric {
+	m := s.Stats.MemoryStats
+
+	// working set is what is not easily reclaimable under pressure,
+	// this is what oom killer and orchestrators act on
+	workingSet := uint64(0)
+	if m.Usage > m.Stats.TotalInactiveFile {
+		workingSet = m.Usage - m.Stats.TotalInactiveFile
+	}
+
+	return []Metric{
+		{"memory.failcnt", Counter, uintValue(m.Failcnt)},
+		{"memory.swap", Gauge, uintValue(m.Stats.Swap)},
+		{"memory.pg_major_fault", Counter, uintValue(m.Stats.TotalPgmafault)},
+		{"memory.hierarchical_limit", Gauge, uintValue(m.Stats.HierarchicalMemoryLimit)},
+		{"memory.hierarchical_memsw_limit", Gauge, uintValue(m.Stats.HierarchicalMemswLimit)},
+		{"memory.working_set", Gauge, uintValue(workingSet)},
+
+		{"memory.local.active_anon", Gauge, uintValue(m.Stats.ActiveAnon)},
+		{"memory.local.active_file", Gauge, uintValue(m.Stats.ActiveFile)},
+		{"memory.local.cache", Gauge, uintValue(m.Stats.Cache)},
+		{"memory.local.inactive_anon", Gauge, uintValue(m.Stats.InactiveAnon)},
+		{"memory.local.inactive_file", Gauge, uintValue(m.Stats.InactiveFile)},
+		{"memory.local.mapped_file", Gauge, uintValue(m.Stats.MappedFile)},
+		{"memory.local.pg_fault", Counter, uintValue(m.Stats.Pgfault)},
+		{"memory.local.pg_major_fault", Counter, uintValue(m.Stats.Pgmajfault)},
+		{"memory.local.pg_in", Counter, uintValue(m.Stats.Pgpgin)},
+		{"memory.local.pg_out", Counter, uintValue(m.Stats.Pgpgout)},
+		{"memory.local.rss", Gauge, uintValue(m.Stats.Rss)},
+		{"memory.local.rss_huge", Gauge, uintValue(m.Stats.RssHuge)},
+		{"memory.local.unevictable", Gauge, uintValue(m.Stats.Unevictable)},
+		{"memory.local.writeback", Gauge, uintValue(m.Stats.Writeback)},
+	}
+}
+
+// cpuUsageMetrics returns cpu usage since the previous stats
+// as a percentage of a single core and as a number of cores,
+// computed the same way as docker stats command does
+func (s Stats) cpuUsageMetrics() []Metric {
+	cpu := s.Stats.CPUStats
+	pre := s.Stats.PreCPUStats
+
+	usage := 0.0
+
+	if cpu.CPUUsage.TotalUsage > pre.CPUUsage.TotalUsage && cpu.SystemCPUUsage > pre.SystemCPUUsage {
+		cpuDelta := float64(cpu.CPUUsage.TotalUsage - pre.CPUUsage.TotalUsage)
+		systemDelta := float64(cpu.SystemCPUUsage - pre.SystemCPUUsage)
+		usage = cpuDelta / systemDelta * float64(len(cpu.CPUUsage.PercpuUsage))
+	}
+
+	return []Metric{
+		{"cpu.percent", Gauge, floatValue(usage * 100)},
+		{"cpu.cores_used", Gauge, floatValue(usage)},
+	}
+}
+
+// blkioMetrics returns block io metrics summed across all devices
+func (s Stats) blkioMetrics() []Metric {
+	b := s.Stats.BlkioStats
+
+	return []Metric{
+		{"blkio.read_bytes", Counter, uintValue(blkioSum(b.IOServiceBytesRecursive, "Read", nil))},
+		{"blkio.write_bytes", Counter, uintValue(blkioSum(b.IOServiceBytesRecursive, "Write", nil))},
+		{"blkio.read_ops", Counter, uintValue(blkioSum(b.IOServicedRecursive, "Read", nil))},
+		{"blkio.write_ops", Counter, uintValue(blkioSum(b.IOServicedRecursive, "Write", nil))},
+		{"blkio.queued", Gauge, uintValue(blkioSum(b.IOQueueRecursive, "Total", nil))},
+		{"blkio.service_time", Counter, uintValue(blkioSum(b.IOServiceTimeRecursive, "Total", nil))},
+		{"blkio.wait_time", Counter, uintValue(blkioSum(b.IOWaitTimeRecursive, "Total", nil))},
+		{"blkio.sectors", Counter, uintValue(blkioSum(b.SectorsRecursive, "", nil))},
+	}
+}
+
+// blkioDeviceMetrics returns read/write bytes and operations for every
+// device, device is identified by major and minor numbers, like 8_0
+func (s Stats) blkioDeviceMetrics() []MetricGroup {
+	b := s.Stats.BlkioStats
+
+	groups := []MetricGroup{}
+
+	for _, d := range blkioDevices(b.IOServiceBytesRecursive, b.IOServicedRecursive) {
+		groups = append(groups, MetricGroup{
+			Prefix:   "blkio",
+			Label:    "device",
+			Instance: fmt.Sprintf("%d_%d", d.Major, d.Minor),
+			Metrics: []Metric{
+				{"read_bytes", Counter, uintValue(blkioSum(b.IOServiceBytesRecursive, "Read", &d))},
+				{"write_bytes", Counter, uintValue(blkioSum(b.IOServiceBytesRecursive, "Write", &d))},
+				{"read_ops", Counter, uintValue(blkioSum(b.IOServicedRecursive, "Read", &d))},
+				{"write_ops", Counter, uintValue(blkioSum(b.IOServicedRecursive, "Write", &d))},
+			},
+		})
+	}
+
+	return groups
+}
+
+// blkioSum sums values of entries with specified operation,
+// only entries of the specified device are counted if it is set
+func blkioSum(entries []docker.BlkioStatsEntry, op string, device *docker.BlkioStatsEntry) uint64 {
+	sum := uint64(0)
+
+	for _, e := range entries {
+		if e.Op != op {
+			continue
+		}
+
+		if device != nil && (e.Major != device.Major || e.Minor != device.Minor) {
+			continue
+		}
+
+		sum += e.Value
+	}
+
+	return sum
+}
+
+// blkioDevices returns sorted list of devices mentioned in entries
+func blkioDevices(lists ...[]docker.BlkioStatsEntry) []docker.BlkioStatsEntry {
+	seen := map[[2]uint64]struct{}{}
+	devices := []docker.BlkioStatsEntry{}
+
+	for _, entries := range lists {
+		for _, e := range entries {
+			key := [2]uint64{e.Major, e.Minor}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+
+			seen[key] = struct{}{}
+			devices = append(devices, docker.BlkioStatsEntry{Major: e.Major, Minor: e.Minor})
+		}
+	}
+
+	sort.Sort(blkioDevicesByNumber(devices))
+
+	return devices
+}
+
+type blkioDevicesByNumber []docker.BlkioStatsEntry
+
+func (d blkioDevicesByNumber) Len() int      { return len(d) }
+func (d blkioDevicesByNumber) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d blkioDevicesByNumber) Less(i, j int) bool {
+	if d[i].Major != d[j].Major {
+		return d[i].Major < d[j].Major
+	}
+
+	return d[i].Minor < d[j].Minor
 }
@@ -0,0 +1,340 @@
+package collector
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func metricValues(s Stats) map[string]float64 {
+	values := map[string]float64{}
+	for _, m := range s.Metrics() {
+		values[m.Name] = m.Value.Float()
+	}
+
+	return values
+}
+
+func TestBlkioMetrics(t *testing.T) {
+	s := docker.Stats{}
+	s.BlkioStats.IOServiceBytesRecursive = []docker.BlkioStatsEntry{
+		{Major: 8, Minor: 16, Op: "Read", Value: 100},
+		{Major: 8, Minor: 16, Op: "Write", Value: 200},
+		{Major: 8, Minor: 16, Op: "Total", Value: 300},
+		{Major: 8, Minor: 0, Op: "Read", Value: 10},
+		{Major: 8, Minor: 0, Op: "Write", Value: 20},
+		{Major: 8, Minor: 0, Op: "Total", Value: 30},
+	}
+	s.BlkioStats.IOServicedRecursive = []docker.BlkioStatsEntry{
+		{Major: 8, Minor: 16, Op: "Read", Value: 1},
+		{Major: 8, Minor: 16, Op: "Write", Value: 2},
+		{Major: 8, Minor: 0, Op: "Read", Value: 3},
+	}
+
+	values := metricValues(Stats{Stats: s})
+
+	expected := map[string]float64{
+		"blkio.read_bytes":       110,
+		"blkio.write_bytes":      220,
+		"blkio.read_ops":         4,
+		"blkio.write_ops":        2,
+		"blkio.8_0.read_bytes":   10,
+		"blkio.8_0.write_ops":    0,
+		"blkio.8_16.write_bytes": 200,
+		"blkio.8_16.read_ops":    1,
+	}
+
+	for name, e := range expected {
+		v, ok := values[name]
+		if !ok {
+			t.Errorf("expected metric %s is missing", name)
+			continue
+		}
+
+		if v != e {
+			t.Errorf("expected %s to be %v, got %v", name, e, v)
+		}
+	}
+}
+
+func TestCPUUsageMetrics(t *testing.T) {
+	s := docker.Stats{}
+	s.PreCPUStats.CPUUsage.TotalUsage = 1000
+	s.PreCPUStats.SystemCPUUsage = 10000
+	s.CPUStats.CPUUsage.TotalUsage = 3000
+	s.CPUStats.CPUUsage.PercpuUsage = []uint64{1000, 1000, 500, 500}
+	s.CPUStats.SystemCPUUsage = 14000
+
+	values := metricValues(Stats{Stats: s})
+
+	if values["cpu.cores_used"] != 2 {
+		t.Errorf("expected cpu.cores_used to be 2, got %v", values["cpu.cores_used"])
+	}
+
+	if values["cpu.percent"] != 200 {
+		t.Errorf("expected cpu.percent to be 200, got %v", values["cpu.percent"])
+	}
+
+	values = metricValues(Stats{Stats: docker.Stats{}})
+
+	if values["cpu.percent"] != 0 {
+		t.Errorf("expected cpu.percent to be 0 without previous stats, got %v", values["cpu.percent"])
+	}
+}
+
+func TestMetricValue(t *testing.T) {
+	s := docker.Stats{}
+	s.CPUStats.CPUUsage.TotalUsage = 1<<53 + 1
+
+	for _, m := range (Stats{Stats: s}).Metrics() {
+		if m.Name != "cpu.total" {
+			continue
+		}
+
+		if v := m.Value.String(); v != "9007199254740993" {
+			t.Errorf("expected cpu.total to keep precision, got %s", v)
+		}
+	}
+
+	if v := floatValue(12.5).String(); v != "12.5" {
+		t.Errorf("expected float value 12.5, got %s", v)
+	}
+
+	if v := intValue(-1).String(); v != "-1" {
+		t.Errorf("expected integer value -1, got %s", v)
+	}
+
+	if v := floatValue(2.5); !v.IsFloat() || v.Int() != 2 || v.Float() != 2.5 {
+		t.Errorf("expected float value 2.5 to be truncated to 2, got %v", v)
+	}
+
+	if v := uintValue(7); v.IsFloat() || v.Int() != 7 || v.Float() != 7 {
+		t.Errorf("expected integer value 7, got %v", v)
+	}
+}
+
+func TestCPUThrottlingMetrics(t *testing.T) {
+	s := docker.Stats{}
+	s.CPUStats.ThrottlingData.Periods = 100
+	s.CPUStats.ThrottlingData.ThrottledPeriods = 20
+	s.CPUStats.ThrottlingData.ThrottledTime = 5000000
+
+	values := metricValues(Stats{Stats: s})
+
+	expected := map[string]float64{
+		"cpu.periods":           100,
+		"cpu.throttled_periods": 20,
+		"cpu.throttled_time":    5000000,
+	}
+
+	for name, e := range expected {
+		if values[name] != e {
+			t.Errorf("expected %s to be %v, got %v", name, e, values[name])
+		}
+	}
+}
+
+func TestPerCPUMetrics(t *testing.T) {
+	s := docker.Stats{}
+	s.CPUStats.CPUUsage.PercpuUsage = []uint64{100, 200}
+
+	if _, ok := metricValues(Stats{Stats: s})["cpu.0.total"]; ok {
+		t.Errorf("expected no per cpu metrics by default")
+	}
+
+	values := metricValues(Stats{Stats: s, perCPU: true})
+
+	if values["cpu.0.total"] != 100 || values["cpu.1.total"] != 200 {
+		t.Errorf("expected per cpu metrics 100 and 200, got %v and %v", values["cpu.0.total"], values["cpu.1.total"])
+	}
+
+	_, groups := (Stats{Stats: s, perCPU: true}).GroupedMetrics()
+	if len(groups) != 2 || groups[1].Label != "cpu" || groups[1].Instance != "1" {
+		t.Errorf("expected per cpu metrics to be grouped by cpu, got %+v", groups)
+	}
+}
+
+func TestNetworkMetrics(t *testing.T) {
+	s := docker.Stats{}
+	s.Networks = map[string]docker.NetworkStats{
+		"eth0": {RxBytes: 100, TxBytes: 10},
+		"eth1": {RxBytes: 200, TxBytes: 20},
+	}
+
+	values := metricValues(Stats{Stats: s})
+
+	expected := map[string]float64{
+		"net.rx_bytes":      300,
+		"net.tx_bytes":      30,
+		"net.eth0.rx_bytes": 100,
+		"net.eth1.tx_bytes": 20,
+	}
+
+	for name, e := range expected {
+		if values[name] != e {
+			t.Errorf("expected %s to be %v, got %v", name, e, values[name])
+		}
+	}
+
+	legacy := docker.Stats{}
+	legacy.Network = docker.NetworkStats{RxBytes: 500}
+
+	values = metricValues(Stats{Stats: legacy})
+
+	if values["net.rx_bytes"] != 500 {
+		t.Errorf("expected net.rx_bytes from legacy network stats to be 500, got %v", values["net.rx_bytes"])
+	}
+
+	if _, ok := metricValues(Stats{Stats: docker.Stats{}})["net.rx_bytes"]; ok {
+		t.Errorf("expected no network metrics without network stats")
+	}
+}
+
+func TestMemoryMetrics(t *testing.T) {
+	s := docker.Stats{}
+	s.MemoryStats.Usage = 1000
+	s.MemoryStats.Failcnt = 3
+	s.MemoryStats.Stats.TotalInactiveFile = 300
+	s.MemoryStats.Stats.Pgmajfault = 7
+	s.MemoryStats.Stats.Cache = 50
+
+	values := metricValues(Stats{Stats: s})
+
+	expected := map[string]float64{
+		"memory.working_set":          700,
+		"memory.failcnt":              3,
+		"memory.local.pg_major_fault": 7,
+		"memory.local.cache":          50,
+	}
+
+	for name, e := range expected {
+		if values[name] != e {
+			t.Errorf("expected %s to be %v, got %v", name, e, values[name])
+		}
+	}
+
+	// vendored client used to decode it from misspelled key
+	decoded := docker.Stats{}
+	err := json.Unmarshal([]byte(`{"memory_stats":{"stats":{"total_pgmajfault":9}}}`), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := metricValues(Stats{Stats: decoded})["memory.pg_major_fault"]; v != 9 {
+		t.Errorf("expected memory.pg_major_fault to be decoded from total_pgmajfault, got %v", v)
+	}
+
+	s.MemoryStats.Stats.TotalInactiveFile = 2000
+
+	if v := metricValues(Stats{Stats: s})["memory.working_set"]; v != 0 {
+		t.Errorf("expected memory.working_set to be 0 when inactive file exceeds usage, got %v", v)
+	}
+}
+
+func TestStateMetrics(t *testing.T) {
+	now := time.Unix(1460000000, 0)
+
+	tests := []struct {
+		state  ContainerState
+		values map[string]float64
+	}{
+		{
+			state: ContainerState{Running: true, StartedAt: now.Add(-time.Minute), Health: "healthy"},
+			values: map[string]float64{
+				"container.state":  1,
+				"container.health": 2,
+				"container.uptime": 60,
+			},
+		},
+		{
+			state: ContainerState{Running: true, Paused: true, StartedAt: now.Add(-time.Hour), Health: "unhealthy", FailingStreak: 4},
+			values: map[string]float64{
+				"container.state":                 2,
+				"container.health":                3,
+				"container.health_failing_streak": 4,
+				"container.uptime":                3600,
+			},
+		},
+		{
+			state: ContainerState{},
+			values: map[string]float64{
+				"container.state":  0,
+				"container.health": 0,
+				"container.uptime": 0,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		values := metricValues(Stats{Stats: docker.Stats{Read: now}, State: test.state})
+
+		for name, e := range test.values {
+			if values[name] != e {
+				t.Errorf("expected %s to be %v for %#v, got %v", name, e, test.state, values[name])
+			}
+		}
+	}
+}
+
+func TestLimitsMetrics(t *testing.T) {
+	tests := []struct {
+		limits ContainerLimits
+		values map[string]float64
+	}{
+		{
+			limits: ContainerLimits{
+				CPUShares:         512,
+				CPUQuota:          50000,
+				CPUPeriod:         100000,
+				CPUSetCPUs:        2,
+				MemoryReservation: 1 << 30,
+				MemorySwap:        -1,
+				BlkioWeight:       300,
+			},
+			values: map[string]float64{
+				"limits.cpu_shares":         512,
+				"limits.cpu_quota":          50000,
+				"limits.cpu_period":         100000,
+				"limits.cpus":               0.5,
+				"limits.cpuset_cpus":        2,
+				"limits.memory_reservation": 1 << 30,
+				"limits.memory_swap":        -1,
+				"limits.blkio_weight":       300,
+			},
+		},
+		{
+			limits: ContainerLimits{CPUQuota: 150000, CPUPeriod: 50000},
+			values: map[string]float64{
+				"limits.cpus": 3,
+			},
+		},
+		{
+			// quota without period does not divide by zero
+			limits: ContainerLimits{CPUQuota: 50000},
+			values: map[string]float64{
+				"limits.cpu_quota":  50000,
+				"limits.cpu_period": 0,
+				"limits.cpus":       0,
+			},
+		},
+		{
+			limits: ContainerLimits{},
+			values: map[string]float64{
+				"limits.cpus":        0,
+				"limits.memory_swap": 0,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		values := metricValues(Stats{Limits: test.limits})
+
+		for name, e := range test.values {
+			if values[name] != e {
+				t.Errorf("expected %s to be %v for %#v, got %v", name, e, test.limits, values[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+// statsdMaxPacketSize keeps packets under typical ethernet mtu
+const statsdMaxPacketSize = 1432
+
+// statsdStateTTL is how long to remember counter values
+// of containers that stopped reporting
+const statsdStateTTL = 10 * time.Minute
+
+// StatsdWriter is responsible for writing data to statsd over udp,
+// gauges are sent as is and counters are sent as deltas
+type StatsdWriter struct {
+	host   string
+	prefix string
+	tags   bool
+	conn   net.Conn
+	state  map[string]*statsdState
+}
+
+type statsdState struct {
+	seen     time.Time
+	counters map[string]int64
+}
+
+// NewStatsdWriter creates new StatsdWriter with specified hostname,
+// metric prefix and statsd address, app, task, host and container id
+// are sent as dogstatsd tags instead of metric name components if tags is set
+func NewStatsdWriter(host, prefix, addr string, tags bool) (*StatsdWriter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsdWriter{
+		host:   host,
+		prefix: prefix,
+		tags:   tags,
+		conn:   conn,
+		state:  map[string]*statsdState{},
+	}, nil
+}
+
+// Write sends stats to statsd, counters are only sent
+// starting from the second stats of the container
+func (w *StatsdWriter) Write(s Stats) error {
+	return w.send(w.lines(s))
+}
+
+// WriteTaskStats sends task crash counters to statsd,
+// they are sent without container tag
+func (w *StatsdWriter) WriteTaskStats(t TaskStats) error {
+	return w.send(w.taskLines(t))
+}
+
+func (w *StatsdWriter) send(lines []string) error {
+	packet := bytes.Buffer{}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line) > statsdMaxPacketSize {
+			err := w.flush(&packet)
+			if err != nil {
+				return err
+			}
+		}
+
+		packet.WriteString(line)
+	}
+
+	return w.flush(&packet)
+}
+
+// Close closes connection to statsd
+func (w *StatsdWriter) Close() error {
+	return w.conn.Close()
+}
+
+func (w *StatsdWriter) flush(packet *bytes.Buffer) error {
+	if packet.Len() == 0 {
+		return nil
+	}
+
+	_, err := w.conn.Write(packet.Bytes())
+	packet.Reset()
+	return err
+}
+
+func (w *StatsdWriter) lines(s Stats) []string {
+	state := w.stateFor(s.ID, s.Stats.Read)
+
+	metrics, groups := s.GroupedMetrics()
+
+	if !w.tags {
+		for _, g := range groups {
+			metrics = append(metrics, g.flatten()...)
+		}
+
+		name := w.prefix + w.host + ".docker_stats." + s.App + "." + s.Task + "."
+		return w.metricLines(state, "", name, "\n", metrics)
+	}
+
+	name := w.prefix + "docker_stats."
+	tags := fmt.Sprintf("|#app:%s,task:%s,host:%s,container:%s", s.App, s.Task, w.host, s.ID)
+
+	lines := w.metricLines(state, "", name, tags+"\n", metrics)
+
+	// instances are sent as tags, metric names are different
+	// from totals, so summing over all tags does not count twice
+	for _, g := range groups {
+		key := g.Prefix + "." + g.Instance + "."
+		suffix := fmt.Sprintf("%s,%s:%s\n", tags, g.Label, g.Instance)
+
+		lines = append(lines, w.metricLines(state, key, name+g.Prefix+"."+g.Label+".", suffix, g.Metrics)...)
+	}
+
+	return lines
+}
+
+func (w *StatsdWriter) taskLines(t TaskStats) []string {
+	// task name cannot clash with container id, because it has a dot
+	state := w.stateFor(t.App+"."+t.Task, t.Time)
+
+	if !w.tags {
+		name := w.prefix + w.host + ".docker_stats." + t.App + "." + t.Task + "."
+		return w.metricLines(state, "", name, "\n", t.Metrics())
+	}
+
+	suffix := fmt.Sprintf("|#app:%s,task:%s,host:%s\n", t.App, t.Task, w.host)
+
+	return w.metricLines(state, "", w.prefix+"docker_stats.", suffix, t.Metrics())
+}
+
+// metricLines formats metrics with specified name prefix and suffix,
+// counters are remembered in state with specified key prefix
+func (w *StatsdWriter) metricLines(state *statsdState, key, name, suffix string, metrics []Metric) []string {
+	lines := []string{}
+
+	for _, m := range metrics {
+		switch m.Kind {
+		case Gauge:
+			// signed gauge value is applied as a difference,
+			// so negative gauge is reset to zero first
+			if m.Value.Float() < 0 {
+				lines = append(lines, fmt.Sprintf("%s%s:0|g%s", name, m.Name, suffix))
+			}
+
+			lines = append(lines, fmt.Sprintf("%s%s:%s|g%s", name, m.Name, m.Value, suffix))
+		case Counter:
+			prev, ok := state.counters[key+m.Name]
+			state.counters[key+m.Name] = m.Value.integer
+
+			// counter reset happens when container restarts
+			if !ok || m.Value.integer < prev {
+				continue
+			}
+
+			lines = append(lines, fmt.Sprintf("%s%s:%d|c%s", name, m.Name, m.Value.integer-prev, suffix))
+		}
+	}
+
+	return lines
+}
+
+// stateFor returns counter state for the container or the task,
+// forgetting the ones that were not seen for a while
+func (w *StatsdWriter) stateFor(id string, now time.Time) *statsdState {
+	for i, state := range w.state {
+		if now.Sub(state.seen) > statsdStateTTL {
+			delete(w.state, i)
+		}
+	}
+
+	state, ok := w.state[id]
+	if !ok {
+		state = &statsdState{counters: map[string]int64{}}
+		w.state[id] = state
+	}
+
+	state.seen = now
+
+	return state
+}
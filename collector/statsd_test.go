@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestStatsdWriter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	w, err := NewStatsdWriter("myhost", "", conn.LocalAddr().String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := docker.Stats{Read: time.Unix(1460000000, 0)}
+	first.CPUStats.CPUUsage.TotalUsage = 1000
+	first.MemoryStats.Usage = 1024
+	first.Networks = map[string]docker.NetworkStats{
+		"eth0": {RxBytes: 100},
+		"eth1": {RxBytes: 200},
+	}
+
+	second := first
+	second.Read = first.Read.Add(time.Second)
+	second.CPUStats.CPUUsage.TotalUsage = 1500
+	second.Networks = map[string]docker.NetworkStats{
+		"eth0": {RxBytes: 150},
+		"eth1": {RxBytes: 300},
+	}
+
+	lines := w.lines(Stats{ID: "abc", App: "myapp", Task: "mytask", Stats: first})
+	for _, line := range lines {
+		if strings.Contains(line, "|c") {
+			t.Errorf("expected no counters on the first stats, got %q", line)
+		}
+	}
+
+	lines = w.lines(Stats{ID: "abc", App: "myapp", Task: "mytask", Stats: second})
+
+	expected := []string{
+		"docker_stats.cpu.total:500|c|#app:myapp,task:mytask,host:myhost,container:abc\n",
+		"docker_stats.memory.usage:1024|g|#app:myapp,task:mytask,host:myhost,container:abc\n",
+		"docker_stats.net.rx_bytes:150|c|#app:myapp,task:mytask,host:myhost,container:abc\n",
+		"docker_stats.net.interface.rx_bytes:50|c|#app:myapp,task:mytask,host:myhost,container:abc,interface:eth0\n",
+		"docker_stats.net.interface.rx_bytes:100|c|#app:myapp,task:mytask,host:myhost,container:abc,interface:eth1\n",
+	}
+
+	for _, e := range expected {
+		found := false
+		for _, line := range lines {
+			if line == e {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected line %q, got %q", e, lines)
+		}
+	}
+
+	err = w.Write(Stats{ID: "abc", App: "myapp", Task: "mytask", Stats: second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, statsdMaxPacketSize)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n > statsdMaxPacketSize || !strings.HasSuffix(string(buf[:n]), "\n") {
+		t.Errorf("unexpected packet: %q", buf[:n])
+	}
+}
+
+func TestStatsdWriterNegativeGauge(t *testing.T) {
+	w := &StatsdWriter{host: "myhost", state: map[string]*statsdState{}}
+
+	lines := w.lines(Stats{ID: "abc", App: "myapp", Task: "mytask", Limits: ContainerLimits{MemorySwap: -1}})
+
+	expected := []string{
+		"myhost.docker_stats.myapp.mytask.limits.memory_swap:0|g\n",
+		"myhost.docker_stats.myapp.mytask.limits.memory_swap:-1|g\n",
+	}
+
+	for i, line := range lines {
+		if line != expected[0] {
+			continue
+		}
+
+		if i+1 == len(lines) || lines[i+1] != expected[1] {
+			t.Errorf("expected negative gauge to be set after reset, got %q", lines)
+		}
+
+		return
+	}
+
+	t.Errorf("expected negative gauge to be reset to zero first, got %q", lines)
+}
+
+func TestStatsdWriterTaskStats(t *testing.T) {
+	w := &StatsdWriter{host: "myhost", tags: true, state: map[string]*statsdState{}}
+
+	now := time.Unix(1460000000, 0)
+
+	w.taskLines(TaskStats{App: "myapp", Task: "mytask", Time: now, Crashes: CrashStats{Failures: 1}})
+	lines := w.taskLines(TaskStats{App: "myapp", Task: "mytask", Time: now.Add(time.Second), Crashes: CrashStats{Failures: 3}})
+
+	expected := "docker_stats.task.failures:2|c|#app:myapp,task:mytask,host:myhost\n"
+
+	for _, line := range lines {
+		if line == expected {
+			return
+		}
+	}
+
+	t.Errorf("expected line %q, got %q", expected, lines)
+}
@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"time"
+)
+
+// CrashStats represents counters of container failures for specific task
+// since collector start, they are shared by all containers of the task
+type CrashStats struct {
+	OOMKills uint64
+	Failures uint64
+	Restarts uint64
+}
+
+// TaskStats represents crash counters of specific task, they are
+// reported once per task rather than for every container of the task
+type TaskStats struct {
+	App     string
+	Task    string
+	Time    time.Time
+	Crashes CrashStats
+}
+
+// TaskStatsWriter is implemented by stats writers
+// that are able to deliver task crash counters
+type TaskStatsWriter interface {
+	WriteTaskStats(t TaskStats) error
+}
+
+// Metrics returns crash counters of the task
+func (t TaskStats) Metrics() []Metric {
+	return []Metric{
+		{"task.oom_kills", Counter, uintValue(t.Crashes.OOMKills)},
+		{"task.failures", Counter, uintValue(t.Crashes.Failures)},
+		{"task.restarts", Counter, uintValue(t.Crashes.Restarts)},
+	}
+}
@@ -3,9 +3,19 @@ package collector
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
-const collectdIntGaugeTemplate = "PUTVAL %s/docker_stats-%s.%s/gauge-%s %d:%d\n"
+const collectdTemplate = "PUTVAL %s/docker_stats-%s.%s/%s-%s interval=%d %d:%s\n"
+
+const collectdNotificationTemplate = "PUTNOTIF severity=%s time=%d host=%s plugin=docker_stats plugin_instance=%s.%s type=docker_event type_instance=%s message=\"%s\"\n"
+
+// StatsWriter is responsible for delivering stats to some backend,
+// CollectdWriter is the default implementation, metrics derived
+// from raw docker stats are returned by Stats.Metrics
+type StatsWriter interface {
+	Write(s Stats) error
+}
 
 // CollectdWriter is responsible for writing data
 // to wrapped writer in collectd exec plugin format
@@ -13,62 +23,34 @@ type CollectdWriter struct {
 	host     string
 	writer   io.Writer
 	interval int
+	derive   bool
 }
 
-// NewCollectdWriter creates new CollectdWriter
-// with specified hostname and writer
-func NewCollectdWriter(host string, writer io.Writer) CollectdWriter {
+// NewCollectdWriter creates new CollectdWriter with specified hostname,
+// writer and stat reporting interval, cumulative metrics
+// are written as derive instead of gauge if derive is set
+func NewCollectdWriter(host string, writer io.Writer, interval int, derive bool) CollectdWriter {
 	return CollectdWriter{
-		host:   host,
-		writer: writer,
+		host:     host,
+		writer:   writer,
+		interval: interval,
+		derive:   derive,
 	}
 }
 
+// Write writes stats in collectd exec plugin format
 func (w CollectdWriter) Write(s Stats) error {
-	return w.writeInts(s)
+	return w.writeMetrics(s.App, s.Task, s.Stats.Read.Unix(), s.Metrics())
 }
 
-func (w CollectdWriter) writeInts(s Stats) error {
-	metrics := map[string]uint64{
-		"cpu.user":   s.Stats.CPUStats.CPUUsage.UsageInUsermode,
-		"cpu.system": s.Stats.CPUStats.CPUUsage.UsageInKernelmode,
-		"cpu.total":  s.Stats.CPUStats.CPUUsage.TotalUsage,
-
-		"memory.limit": s.Stats.MemoryStats.Limit,
-		"memory.max":   s.Stats.MemoryStats.MaxUsage,
-		"memory.usage": s.Stats.MemoryStats.Usage,
-
-		"memory.active_anon":   s.Stats.MemoryStats.Stats.TotalActiveAnon,
-		"memory.active_file":   s.Stats.MemoryStats.Stats.TotalActiveFile,
-		"memory.cache":         s.Stats.MemoryStats.Stats.TotalCache,
-		"memory.inactive_anon": s.Stats.MemoryStats.Stats.TotalInactiveAnon,
-		"memory.inactive_file": s.Stats.MemoryStats.Stats.TotalInactiveFile,
-		"memory.mapped_file":   s.Stats.MemoryStats.Stats.TotalMappedFile,
-		"memory.pg_fault":      s.Stats.MemoryStats.Stats.TotalPgfault,
-		"memory.pg_in":         s.Stats.MemoryStats.Stats.TotalPgpgin,
-		"memory.pg_out":        s.Stats.MemoryStats.Stats.TotalPgpgout,
-		"memory.rss":           s.Stats.MemoryStats.Stats.TotalRss,
-		"memory.rss_huge":      s.Stats.MemoryStats.Stats.TotalRssHuge,
-		"memory.unevictable":   s.Stats.MemoryStats.Stats.TotalUnevictable,
-		"memory.writeback":     s.Stats.MemoryStats.Stats.TotalWriteback,
-	}
-
-	for _, network := range s.Stats.Networks {
-		metrics["net.rx_bytes"] += network.RxBytes
-		metrics["net.rx_dropped"] += network.RxDropped
-		metrics["net.rx_errors"] += network.RxErrors
-		metrics["net.rx_packets"] += network.RxPackets
-
-		metrics["net.tx_bytes"] += network.TxBytes
-		metrics["net.tx_dropped"] += network.TxDropped
-		metrics["net.tx_errors"] += network.TxErrors
-		metrics["net.tx_packets"] += network.TxPackets
-	}
-
-	t := s.Stats.Read.Unix()
+// WriteTaskStats writes task crash counters in collectd exec plugin format
+func (w CollectdWriter) WriteTaskStats(t TaskStats) error {
+	return w.writeMetrics(t.App, t.Task, t.Time.Unix(), t.Metrics())
+}
 
-	for k, v := range metrics {
-		err := w.writeInt(s, k, t, v)
+func (w CollectdWriter) writeMetrics(app, task string, t int64, metrics []Metric) error {
+	for _, m := range metrics {
+		err := w.writeMetric(app, task, w.collectdType(m), m.Name, t, m.Value)
 		if err != nil {
 			return err
 		}
@@ -77,8 +59,56 @@ func (w CollectdWriter) writeInts(s Stats) error {
 	return nil
 }
 
-func (w CollectdWriter) writeInt(s Stats, k string, t int64, v uint64) error {
-	msg := fmt.Sprintf(collectdIntGaugeTemplate, w.host, s.App, s.Task, k, t, v)
+func (w CollectdWriter) collectdType(m Metric) string {
+	if w.derive && m.Kind == Counter {
+		return "derive"
+	}
+
+	return "gauge"
+}
+
+func (w CollectdWriter) writeMetric(app, task, typ, k string, t int64, v MetricValue) error {
+	msg := fmt.Sprintf(collectdTemplate, w.host, app, task, typ, k, w.interval, t, v)
 	_, err := w.writer.Write([]byte(msg))
 	return err
 }
+
+// WriteEvent writes container event as collectd notification
+func (w CollectdWriter) WriteEvent(e Event) error {
+	message := fmt.Sprintf("container %s of %s.%s: %s", e.ID, e.App, e.Task, e.Status)
+	if e.Status == "die" {
+		message += fmt.Sprintf(" with exit code %d", e.ExitCode)
+	}
+
+	typeInstance := strings.Replace(e.Status, ": ", "_", -1)
+
+	msg := fmt.Sprintf(
+		collectdNotificationTemplate,
+		collectdSeverity(e),
+		e.Time.Unix(),
+		w.host,
+		e.App,
+		e.Task,
+		typeInstance,
+		strings.Replace(message, `"`, `'`, -1),
+	)
+
+	_, err := w.writer.Write([]byte(msg))
+	return err
+}
+
+// collectdSeverity maps container event to notification severity
+func collectdSeverity(e Event) string {
+	switch e.Status {
+	case "oom", "health_status: unhealthy":
+		return "failure"
+	case "die":
+		if e.ExitCode != 0 {
+			return "failure"
+		}
+	case "kill", "stop", "pause", "health_status: starting":
+		return "warning"
+	}
+
+	return "okay"
+}
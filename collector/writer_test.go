@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestCollectdWriterTypes(t *testing.T) {
+	s := docker.Stats{Read: time.Unix(1460000000, 0)}
+	s.CPUStats.CPUUsage.TotalUsage = 1000
+	s.MemoryStats.Usage = 1024
+
+	tests := map[bool][]string{
+		false: {
+			"PUTVAL myhost/docker_stats-myapp.mytask/gauge-cpu.total interval=10 1460000000:1000\n",
+			"PUTVAL myhost/docker_stats-myapp.mytask/gauge-memory.usage interval=10 1460000000:1024\n",
+		},
+		true: {
+			"PUTVAL myhost/docker_stats-myapp.mytask/derive-cpu.total interval=10 1460000000:1000\n",
+			"PUTVAL myhost/docker_stats-myapp.mytask/gauge-memory.usage interval=10 1460000000:1024\n",
+		},
+	}
+
+	for derive, expected := range tests {
+		buf := bytes.Buffer{}
+
+		w := NewCollectdWriter("myhost", &buf, 10, derive)
+
+		err := w.Write(Stats{App: "myapp", Task: "mytask", Stats: s})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range expected {
+			if !strings.Contains(buf.String(), e) {
+				t.Errorf("expected %q with derive=%v, got:\n%s", e, derive, buf.String())
+			}
+		}
+	}
+}
+
+func TestCollectdWriterEvents(t *testing.T) {
+	tests := map[Event]string{
+		Event{App: "myapp", Task: "mytask", ID: "abc", Status: "die", ExitCode: 137, Time: time.Unix(1460000000, 0)}:       `PUTNOTIF severity=failure time=1460000000 host=myhost plugin=docker_stats plugin_instance=myapp.mytask type=docker_event type_instance=die message="container abc of myapp.mytask: die with exit code 137"` + "\n",
+		Event{App: "myapp", Task: "mytask", ID: "abc", Status: "die", Time: time.Unix(1460000000, 0)}:                      `PUTNOTIF severity=okay time=1460000000 host=myhost plugin=docker_stats plugin_instance=myapp.mytask type=docker_event type_instance=die message="container abc of myapp.mytask: die with exit code 0"` + "\n",
+		Event{App: "myapp", Task: "mytask", ID: "abc", Status: "health_status: unhealthy", Time: time.Unix(1460000000, 0)}: `PUTNOTIF severity=failure time=1460000000 host=myhost plugin=docker_stats plugin_instance=myapp.mytask type=docker_event type_instance=health_status_unhealthy message="container abc of myapp.mytask: health_status: unhealthy"` + "\n",
+		Event{App: "myapp", Task: "mytask", ID: "abc", Status: "pause", Time: time.Unix(1460000000, 0)}:                    `PUTNOTIF severity=warning time=1460000000 host=myhost plugin=docker_stats plugin_instance=myapp.mytask type=docker_event type_instance=pause message="container abc of myapp.mytask: pause"` + "\n",
+	}
+
+	for e, expected := range tests {
+		buf := bytes.Buffer{}
+
+		w := NewCollectdWriter("myhost", &buf, 10, false)
+
+		err := w.WriteEvent(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != expected {
+			t.Errorf("expected %q, got %q", expected, buf.String())
+		}
+	}
+}
+
+func TestCollectdWriterTaskStats(t *testing.T) {
+	buf := bytes.Buffer{}
+
+	w := NewCollectdWriter("myhost", &buf, 10, true)
+
+	err := w.WriteTaskStats(TaskStats{App: "myapp", Task: "mytask", Time: time.Unix(1460000000, 0), Crashes: CrashStats{OOMKills: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "PUTVAL myhost/docker_stats-myapp.mytask/derive-task.oom_kills interval=10 1460000000:3\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q, got:\n%s", expected, buf.String())
+	}
+}
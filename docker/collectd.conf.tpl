@@ -21,5 +21,5 @@ LoadPlugin write_graphite
 
 LoadPlugin exec
 <Plugin exec>
-  Exec "collectd-docker-collector" "/usr/bin/collectd-docker-collector" "-endpoint" "unix:///var/run/docker.sock" "-host" "{{ .Env "COLLECTD_HOST" }}" "-interval" "{{ .Env "COLLECTD_INTERVAL" }}"
+  Exec "collectd-docker-collector" "/usr/bin/collectd-docker-collector" "-endpoint" "unix:///var/run/docker.sock" "-host" "{{ .Env "COLLECTD_HOST" }}" "-interval" "{{ .Env "COLLECTD_INTERVAL" }}" "-derive={{ .Env "COLLECTD_DERIVE" }}" "-procfs={{ .Env "COLLECTD_PROCFS" }}" "-aggregate={{ .Env "COLLECTD_AGGREGATE" }}"
 </Plugin>
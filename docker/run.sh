@@ -11,6 +11,9 @@ fi
 export GRAPHITE_PORT=${GRAPHITE_PORT:-2003}
 export GRAPHITE_PREFIX=${GRAPHITE_PREFIX:-collectd.}
 export COLLECTD_INTERVAL=${COLLECTD_INTERVAL:-10}
+export COLLECTD_DERIVE=${COLLECTD_DERIVE:-false}
+export COLLECTD_PROCFS=${COLLECTD_PROCFS:-}
+export COLLECTD_AGGREGATE=${COLLECTD_AGGREGATE:-}
 
 # Adding a user if needed to be able to communicate with docker
 GROUP=nobody
@@ -31,5 +34,13 @@ if [ ! $(getent passwd "collectd-docker-collector") ]; then
   useradd -g "${GROUP}" collectd-docker-collector
 fi
 
+if [ "${WRITER}" = "graphite" ]; then
+  exec chroot --userspec="collectd-docker-collector:${GROUP}" / \
+    /usr/bin/collectd-docker-collector -endpoint unix:///var/run/docker.sock \
+    -host "${COLLECTD_HOST}" -interval "${COLLECTD_INTERVAL}" -writer graphite \
+    -graphite "${GRAPHITE_HOST}:${GRAPHITE_PORT}" -graphite-prefix "${GRAPHITE_PREFIX}" \
+    -procfs "${COLLECTD_PROCFS}" -aggregate "${COLLECTD_AGGREGATE}" "$@"
+fi
+
 exec reefer -t /etc/collectd/collectd.conf.tpl:/tmp/collectd.conf -E \
   collectd -f -C /tmp/collectd.conf "$@" > /dev/null